@@ -0,0 +1,263 @@
+package termo
+
+import (
+	"os"
+	"strings"
+)
+
+// caps holds the control sequences used to drive a terminal,
+// taken from its terminfo entry
+type caps struct {
+	clear, cup   string
+	ed           string
+	cuu, cud     string
+	cuf          string
+	civis, cnorm string
+	smcup, rmcup string
+	smkx, rmkx   string
+	ss           string
+
+	// Attributes and colors, for terminals that don't use
+	// standard SGR sequences, which is when ansi is not set
+	ansi                               bool
+	sgr0, setaf, setab                 string
+	bold, dim, smul, blink, rev, invis string
+	sitm                               string
+
+	// keys holds the sequences sent by special keys
+	keys []termKey
+}
+
+// termKey is a key sequence from a terminfo entry
+type termKey struct {
+	seq string
+	key Key
+}
+
+// xtermCaps is used when there's no terminfo entry for the terminal
+var xtermCaps = &caps{
+	clear: "\033[2J",
+	cup:   "\033[%i%p1%d;%p2%dH",
+	ed:    "\033[J",
+	cuu:   "\033[%p1%dA",
+	cud:   "\033[%p1%dB",
+	cuf:   "\033[%p1%dC",
+	civis: "\033[?25l",
+	cnorm: "\033[?25h",
+	smcup: "\033[?1049h",
+	rmcup: "\033[?1049l",
+	ss:    "\033[%p1%d q",
+	ansi:  true,
+	sgr0:  "\033[0m",
+}
+
+// terminfoKeys maps the key capabilities to their keys
+var terminfoKeys = []struct {
+	cap int
+	key Key
+}{
+	{tiKbs, KeyBackspace},
+	{tiKdch1, KeyDelete},
+	{tiKich1, KeyInsert},
+	{tiKhome, KeyHome},
+	{tiKend, KeyEnd},
+	{tiKpp, KeyPgUp},
+	{tiKnp, KeyPgDn},
+	{tiKcuu1, KeyUp},
+	{tiKcud1, KeyDown},
+	{tiKcub1, KeyLeft},
+	{tiKcuf1, KeyRight},
+	{tiKf1, KeyF1},
+	{tiKf2, KeyF2},
+	{tiKf2 + 1, KeyF3},
+	{tiKf2 + 2, KeyF4},
+	{tiKf2 + 3, KeyF5},
+	{tiKf2 + 4, KeyF6},
+	{tiKf2 + 5, KeyF7},
+	{tiKf2 + 6, KeyF8},
+	{tiKf2 + 7, KeyF9},
+	{tiKf10, KeyF10},
+	{tiKf11, KeyF11},
+	{tiKf12, KeyF12},
+}
+
+// loadCaps returns the capabilities for term and the colors it
+// supports, falling back to xterm sequences if its terminfo entry
+// can't be read
+func loadCaps(term string) (*caps, ColorMode, error) {
+	ti, err := loadTerminfo(term)
+	if err != nil {
+		return xtermCaps, termColorMode(term, nil), err
+	}
+	mode := termColorMode(term, ti)
+	c := &caps{
+		clear: ti.str(tiClear),
+		cup:   ti.str(tiCup),
+		ed:    ti.str(tiEd),
+		cuu:   ti.str(tiCuu),
+		cud:   ti.str(tiCud),
+		cuf:   ti.str(tiCuf),
+		civis: ti.str(tiCivis),
+		cnorm: ti.str(tiCnorm),
+		smcup: ti.str(tiSmcup),
+		rmcup: ti.str(tiRmcup),
+		smkx:  ti.str(tiSmkx),
+		rmkx:  ti.str(tiRmkx),
+		ss:    ti.ext["Ss"],
+		sgr0:  ti.str(tiSgr0),
+		setaf: ti.str(tiSetaf),
+		setab: ti.str(tiSetab),
+		bold:  ti.str(tiBold),
+		dim:   ti.str(tiDim),
+		smul:  ti.str(tiSmul),
+		blink: ti.str(tiBlink),
+		rev:   ti.str(tiRev),
+		invis: ti.str(tiInvis),
+		sitm:  ti.str(tiSitm),
+	}
+	if c.cup == "" || c.cuu == "" || c.cud == "" || c.cuf == "" {
+		// Not much can be done without cursor addressing, so
+		// hope the terminal understands the usual sequences
+		return xtermCaps, mode, nil
+	}
+	if c.clear == "" {
+		c.clear = xtermCaps.clear
+	}
+	if c.ed == "" {
+		c.ed = xtermCaps.ed
+	}
+	if c.ss == "" {
+		// Many entries lack it, even if most terminals
+		// support it, and the rest ignore it
+		c.ss = xtermCaps.ss
+	}
+	if c.sgr0 == "" {
+		c.sgr0 = xtermCaps.sgr0
+	}
+	c.ansi = isSGR(c.setaf, "31", "38;5;1") && isSGR(c.setab, "41", "48;5;1") && isSGR(c.bold, "1")
+	for _, k := range terminfoKeys {
+		if seq := ti.str(k.cap); seq != "" {
+			c.keys = append(c.keys, termKey{seq, k.key})
+		}
+	}
+	return c, mode, nil
+}
+
+// isSGR tells if the capability is missing, or is one of
+// the given SGR sequences once color 1 is passed to it
+func isSGR(capability string, params ...string) bool {
+	if capability == "" {
+		return true
+	}
+	seq := tparm(capability, 1)
+	for _, p := range params {
+		if seq == "\033["+p+"m" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTerm sets the terminal type, which selects the terminfo entry
+// used to drive it. By default, it's taken from the TERM environment
+// variable, but Screens for remote sessions (like SSH ones) should
+// use the type the client reported. If there's no terminfo entry for
+// term, xterm sequences are used, and an error is returned. The
+// colors the terminal supports are detected again from its entry,
+// unless they were set with SetColorMode.
+func (s *Screen) SetTerm(term string) error {
+	c, mode, err := loadCaps(term)
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setCaps(c, mode)
+	s.front = nil
+	return err
+}
+
+// caps returns the control sequences for the terminal. Unless set
+// with SetTerm, they're loaded the first time they're needed (usually
+// by Init) from the terminfo entry for the TERM environment variable.
+// Must be called with s.outMu held.
+func (s *Screen) caps() *caps {
+	if s.termCaps == nil {
+		c, mode, _ := loadCaps(os.Getenv("TERM"))
+		if m, ok := envColorMode(); ok {
+			mode = m
+		}
+		s.setCaps(c, mode)
+	}
+	return s.termCaps
+}
+
+func (s *Screen) setCaps(c *caps, mode ColorMode) {
+	s.termCaps = c
+	s.parser.keys = c.keys
+	if !s.colorModeSet {
+		s.colorMode = mode
+	}
+}
+
+// moveTo writes the sequence that moves the cursor to x,y. In
+// inline mode, coordinates are relative to the top of the drawing
+// area, and the cursor is moved relative to its current row.
+func (s *Screen) moveTo(x, y int) {
+	if !s.inline {
+		s.buf.WriteString(tparm(s.caps().cup, y, x))
+		return
+	}
+	if y >= s.inlineH {
+		y = s.inlineH - 1
+	}
+	if y < 0 {
+		y = 0
+	}
+	s.buf.WriteByte('\r')
+	if y < s.row {
+		s.buf.WriteString(tparm(s.caps().cuu, s.row-y))
+	} else if y > s.row {
+		s.buf.WriteString(tparm(s.caps().cud, y-s.row))
+	}
+	if x > 0 {
+		s.buf.WriteString(tparm(s.caps().cuf, x))
+	}
+	s.row = y
+}
+
+// stateDiff returns the sequence that changes the terminal pen from
+// one CellState to another. For terminals that use standard SGR
+// sequences, only the parameters that differ are sent. Otherwise,
+// attributes are reset and set again through their capabilities.
+func (c *caps) stateDiff(from, to CellState) string {
+	if c.ansi {
+		return sgrDiff(from, to)
+	}
+	if from == to {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(c.sgr0)
+	attrs := []struct {
+		attr Attribute
+		cap  string
+	}{
+		{AttrBold, c.bold},
+		{AttrDim, c.dim},
+		{AttrItalic, c.sitm},
+		{AttrUnder | AttrDoubleUnder | AttrCurlyUnder, c.smul},
+		{AttrBlink, c.blink},
+		{AttrRev, c.rev},
+		{AttrHid, c.invis},
+	}
+	for _, a := range attrs {
+		if to.Attrib&a.attr != 0 {
+			b.WriteString(a.cap)
+		}
+	}
+	if to.FGColor != ColorDefault && c.setaf != "" {
+		b.WriteString(tparm(c.setaf, to.FGColor.index()))
+	}
+	if to.BGColor != ColorDefault && c.setab != "" {
+		b.WriteString(tparm(c.setab, to.BGColor.index()))
+	}
+	return b.String()
+}
@@ -0,0 +1,286 @@
+package termo
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Color holds character color information. It can be the terminal
+// default color (the zero value), one of the 256 colors in the
+// terminal palette, or a 24-bit RGB color.
+type Color uint32
+
+// The top byte of a Color tells its kind, and
+// the rest holds either a palette index or RGB
+const (
+	colorKind    Color = 0xff << 24
+	colorIndexed Color = 1 << 24
+	colorRGB     Color = 2 << 24
+)
+
+// ColorDefault is the default color of the terminal
+const ColorDefault Color = 0
+
+// Different colors to use as attributes. These are the first
+// 8 colors of the terminal palette, and are supported everywhere.
+const (
+	ColorBlack Color = colorIndexed | iota
+	ColorRed
+	ColorGreen
+	ColorYellow
+	ColorBlue
+	ColorMagenta
+	ColorCyan
+	ColorGray
+)
+
+// Indexed returns the color with index n in the terminal palette.
+// Indexes 0-15 are the basic colors, 16-231 a 6x6x6 color cube,
+// and 232-255 a grayscale ramp.
+func Indexed(n uint8) Color {
+	return colorIndexed | Color(n)
+}
+
+// RGB returns a 24-bit color
+func RGB(r, g, b uint8) Color {
+	return colorRGB | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// Light returns the "ligther" version for that color. Only the
+// first 8 colors of the palette have a lighter version, other
+// colors are returned unchanged.
+func (c Color) Light() Color {
+	if c&colorKind == colorIndexed && c&0xff < 8 {
+		return c + 8
+	}
+	return c
+}
+
+// Base SGR codes for each place a color can be used
+const (
+	sgrForeground = 30
+	sgrBackground = 40
+	sgrUnderline  = 50
+)
+
+// sgr returns the SGR parameters that select c as the foreground,
+// background or underline color, depending on base
+func (c Color) sgr(base int) string {
+	switch c & colorKind {
+	case colorIndexed:
+		n := int(c & 0xff)
+		switch {
+		case base == sgrUnderline:
+			// Underline colors only have the extended form, and use
+			// colons, so terminals that don't know about them skip
+			// the whole parameter instead of reading the color
+			// number as another attribute
+			return fmt.Sprintf("%d:5:%d", base+8, n)
+		case n < 8:
+			return strconv.Itoa(base + n)
+		case n < 16:
+			return strconv.Itoa(base + 60 + n - 8)
+		}
+		return fmt.Sprintf("%d;5;%d", base+8, n)
+	case colorRGB:
+		if base == sgrUnderline {
+			return fmt.Sprintf("%d:2::%d:%d:%d", base+8, c>>16&0xff, c>>8&0xff, c&0xff)
+		}
+		return fmt.Sprintf("%d;2;%d;%d;%d", base+8, c>>16&0xff, c>>8&0xff, c&0xff)
+	}
+	return strconv.Itoa(base + 9)
+}
+
+// index returns the palette index of c, replacing
+// RGB colors with the closest palette ones
+func (c Color) index() int {
+	return int(c.downgrade(ColorMode256) & 0xff)
+}
+
+// ColorMode tells which colors a terminal can show
+type ColorMode int
+
+// Color modes, from least to most capable
+const (
+	// ColorModeNone doesn't show any colors
+	ColorModeNone ColorMode = iota
+	// ColorMode16 shows the 16 basic colors
+	ColorMode16
+	// ColorMode256 shows the 256 colors of the palette
+	ColorMode256
+	// ColorModeTrueColor shows any 24-bit color
+	ColorModeTrueColor
+)
+
+// DetectColorMode guesses the colors supported by the terminal
+// from the environment: NO_COLOR (see https://no-color.org),
+// COLORTERM, TERM and the terminfo entry for TERM, including
+// the Tc and RGB extensions for 24-bit colors
+func DetectColorMode() ColorMode {
+	if m, ok := envColorMode(); ok {
+		return m
+	}
+	term := os.Getenv("TERM")
+	ti, _ := loadTerminfo(term)
+	return termColorMode(term, ti)
+}
+
+// envColorMode returns the colors forced by
+// the NO_COLOR or COLORTERM variables, if any
+func envColorMode() (ColorMode, bool) {
+	if os.Getenv("NO_COLOR") != "" {
+		return ColorModeNone, true
+	}
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return ColorModeTrueColor, true
+	}
+	return 0, false
+}
+
+// termColorMode guesses the colors supported by a terminal from
+// its type and its terminfo entry alone, which can be nil
+func termColorMode(term string, ti *terminfo) ColorMode {
+	switch {
+	case term == "dumb":
+		return ColorModeNone
+	case strings.HasSuffix(term, "-direct"):
+		return ColorModeTrueColor
+	case strings.Contains(term, "256color"):
+		return ColorMode256
+	}
+	if ti != nil {
+		if _, rgb := ti.ext["RGB"]; rgb || ti.flag("Tc") {
+			return ColorModeTrueColor
+		}
+		switch n := ti.number(tiColors); {
+		case n >= 1<<24:
+			return ColorModeTrueColor
+		case n >= 256:
+			return ColorMode256
+		case n >= 8:
+			return ColorMode16
+		case n >= 0:
+			return ColorModeNone
+		}
+	}
+	return ColorMode16
+}
+
+// basicColors holds the RGB values of the 16 basic colors,
+// as used by xterm, to find the closest one to other colors
+var basicColors = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels holds the RGB component values used
+// by the 6x6x6 color cube of the 256 color palette
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// rgb returns the RGB components of a non-default color
+func (c Color) rgb() (int, int, int) {
+	if c&colorKind == colorRGB {
+		return int(c >> 16 & 0xff), int(c >> 8 & 0xff), int(c & 0xff)
+	}
+	n := int(c & 0xff)
+	switch {
+	case n < 16:
+		return basicColors[n][0], basicColors[n][1], basicColors[n][2]
+	case n < 232:
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+	}
+	gray := 8 + (n-232)*10
+	return gray, gray, gray
+}
+
+func colorDistance(r1, g1, b1, r2, g2, b2 int) int {
+	return (r1-r2)*(r1-r2) + (g1-g2)*(g1-g2) + (b1-b2)*(b1-b2)
+}
+
+// closestCubeLevel returns the index of the color
+// cube level closest to the color component v
+func closestCubeLevel(v int) int {
+	best := 0
+	for i, l := range cubeLevels {
+		if abs(l-v) < abs(cubeLevels[best]-v) {
+			best = i
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// downgrade returns the closest color to c that can be shown in
+// a terminal with the given color mode
+func (c Color) downgrade(mode ColorMode) Color {
+	if c == ColorDefault {
+		return c
+	}
+	kind := c & colorKind
+	switch mode {
+	case ColorModeNone:
+		return ColorDefault
+	case ColorModeTrueColor:
+		return c
+	case ColorMode256:
+		if kind == colorIndexed {
+			return c
+		}
+		r, g, b := c.rgb()
+		// Pick the closest between the color cube and the gray ramp
+		ri, gi, bi := closestCubeLevel(r), closestCubeLevel(g), closestCubeLevel(b)
+		cube := Indexed(uint8(16 + ri*36 + gi*6 + bi))
+		grayIndex := ((r+g+b)/3 - 3) / 10
+		if grayIndex < 0 {
+			grayIndex = 0
+		} else if grayIndex > 23 {
+			grayIndex = 23
+		}
+		gray := Indexed(uint8(232 + grayIndex))
+		cr, cg, cb := cube.rgb()
+		gr, gg, gb := gray.rgb()
+		if colorDistance(r, g, b, gr, gg, gb) < colorDistance(r, g, b, cr, cg, cb) {
+			return gray
+		}
+		return cube
+	}
+
+	if kind == colorIndexed && c&0xff < 16 {
+		return c
+	}
+	r, g, b := c.rgb()
+	best := 0
+	for i, bc := range basicColors {
+		if colorDistance(r, g, b, bc[0], bc[1], bc[2]) < colorDistance(r, g, b, basicColors[best][0], basicColors[best][1], basicColors[best][2]) {
+			best = i
+		}
+	}
+	return Indexed(uint8(best))
+}
+
+// downgrade returns s with its colors changed to the
+// closest ones that can be shown with the given color mode
+func (s CellState) downgrade(mode ColorMode) CellState {
+	s.FGColor = s.FGColor.downgrade(mode)
+	s.BGColor = s.BGColor.downgrade(mode)
+	if mode < ColorMode256 {
+		// Terminals with only the basic colors don't
+		// support underline colors either
+		s.ULColor = ColorDefault
+	} else {
+		s.ULColor = s.ULColor.downgrade(mode)
+	}
+	return s
+}
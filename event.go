@@ -0,0 +1,104 @@
+package termo
+
+// Event is implemented by all the values that
+// can arrive through the Screen input
+type Event interface {
+	isEvent()
+}
+
+// KeyEvent is reported when a key is pressed. For keys that
+// produce text, Key is KeyRune and Rune holds the character.
+// Mod holds the modifier keys held down, although Shift is
+// not reported for text keys, as it's already part of Rune.
+// Action is always KeyPress, unless the kitty keyboard
+// protocol is enabled with event reporting.
+type KeyEvent struct {
+	Key    Key
+	Rune   rune
+	Mod    Modifier
+	Action KeyAction
+}
+
+// KeyAction tells what happened to the key in a KeyEvent
+type KeyAction int
+
+// Different actions reported by key events
+const (
+	KeyPress KeyAction = iota
+	KeyRepeat
+	KeyRelease
+)
+
+// MouseAction tells what happened in a MouseEvent
+type MouseAction int
+
+// Different actions reported by mouse events
+const (
+	MouseMove MouseAction = iota
+	MousePress
+	MouseRelease
+	// MouseDrag is reported when the mouse moves
+	// while a button is being held down
+	MouseDrag
+)
+
+// MouseButton identifies the button involved in a MouseEvent
+type MouseButton int
+
+// Mouse buttons that can be reported by mouse events. Wheel
+// movements are reported as presses of the wheel buttons.
+const (
+	MouseNone MouseButton = iota
+	MouseLeft
+	MouseMiddle
+	MouseRight
+	MouseWheelUp
+	MouseWheelDown
+	MouseWheelLeft
+	MouseWheelRight
+)
+
+// MouseEvent is reported when the mouse moves or a button is
+// pressed or released. Coords start at [0,0] for the upper-left
+// corner. Mod holds the modifier keys held down, although most
+// terminals reserve some combinations for their own use. Mouse
+// events must be enabled with SetMouseMode or EnableMouseEvents.
+type MouseEvent struct {
+	X, Y   int
+	Button MouseButton
+	Action MouseAction
+	Mod    Modifier
+}
+
+// ResizeEvent is reported when the terminal changes size.
+// Framebuffers can be adapted to it with Framebuffer.Resize.
+type ResizeEvent struct {
+	W, H int
+}
+
+// PasteEvent carries a whole block of pasted text, with line
+// breaks as \n. Paste events must be enabled with
+// EnableBracketedPaste, otherwise pasted text arrives as keys.
+type PasteEvent struct {
+	Text string
+}
+
+// FocusEvent is reported when the terminal window gains or
+// loses the focus. Focus events must be enabled with
+// EnableFocusEvents.
+type FocusEvent struct {
+	Focused bool
+}
+
+// RedrawEvent is reported when the terminal contents got lost, like
+// when the program is brought back to the foreground after being
+// suspended, so the framebuffer has to be flushed again. That
+// flush repaints every cell.
+type RedrawEvent struct{}
+
+func (KeyEvent) isEvent()    {}
+func (MouseEvent) isEvent()  {}
+func (ResizeEvent) isEvent() {}
+func (PasteEvent) isEvent()  {}
+func (FocusEvent) isEvent()  {}
+func (RedrawEvent) isEvent() {}
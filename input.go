@@ -0,0 +1,755 @@
+package termo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const keyEscape = 27
+
+// inputParser turns the raw bytes read from a terminal into Events.
+// Incomplete sequences are kept around until the rest of their bytes
+// arrive, so sequences split across several reads are handled, as
+// well as several events arriving in a single read.
+type inputParser struct {
+	pending []byte
+
+	// pasting is set between the bracketed paste start and end
+	// markers, while the pasted text accumulates in paste
+	pasting bool
+	paste   []byte
+
+	// keys holds the sequences for special keys
+	// listed in the terminal's terminfo entry
+	keys []termKey
+}
+
+// pasteStart is returned by parseEvent for the
+// sequence that starts a bracketed paste
+type pasteStart struct{}
+
+func (pasteStart) isEvent() {}
+
+var pasteEnd = []byte("\033[201~")
+
+// feed appends b to the pending input, and returns all
+// the events that can be completely decoded from it
+func (p *inputParser) feed(b []byte) []Event {
+	p.pending = append(p.pending, b...)
+	var events []Event
+	for len(p.pending) > 0 {
+		if p.pasting {
+			if !p.feedPaste() {
+				break
+			}
+			events = append(events, PasteEvent{normalizeNewlines(string(p.paste))})
+			p.paste = nil
+			continue
+		}
+		ev, n := p.parseKey(p.pending)
+		if n == 0 {
+			// Incomplete sequence, wait for more input
+			break
+		}
+		p.pending = p.pending[n:]
+		if _, ok := ev.(pasteStart); ok {
+			p.pasting = true
+			continue
+		}
+		if ev != nil {
+			events = append(events, ev)
+		}
+	}
+	if len(p.pending) == 0 {
+		p.pending = nil
+	}
+	return events
+}
+
+// feedPaste moves pending input into the paste buffer, and returns
+// true if the paste end marker was found. Everything is taken as
+// text until then, including escape sequences.
+func (p *inputParser) feedPaste() bool {
+	if i := bytes.Index(p.pending, pasteEnd); i >= 0 {
+		p.paste = append(p.paste, p.pending[:i]...)
+		p.pending = p.pending[i+len(pasteEnd):]
+		p.pasting = false
+		return true
+	}
+	// Keep anything that could be the start of the end
+	// marker, in case the rest of it arrives later
+	keep := len(pasteEnd) - 1
+	if keep > len(p.pending) {
+		keep = len(p.pending)
+	}
+	n := len(p.pending) - keep
+	p.paste = append(p.paste, p.pending[:n]...)
+	p.pending = append([]byte(nil), p.pending[n:]...)
+	return false
+}
+
+// normalizeNewlines replaces the line breaks sent by
+// terminals when pasting (usually \r) with \n
+func normalizeNewlines(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	return strings.Replace(s, "\r", "\n", -1)
+}
+
+// flush decodes whatever incomplete input is still pending,
+// assuming the rest of its bytes are never going to arrive.
+// A paste in progress is kept until its end marker arrives.
+func (p *inputParser) flush() []Event {
+	if p.pasting {
+		return nil
+	}
+	var events []Event
+	for len(p.pending) > 0 {
+		if p.pending[0] == keyEscape {
+			events = append(events, KeyEvent{Key: KeyEscape})
+		} else {
+			events = append(events, KeyEvent{Key: KeyRune, Rune: utf8.RuneError})
+		}
+		rest := p.pending[1:]
+		p.pending = nil
+		events = append(events, p.feed(rest)...)
+	}
+	return events
+}
+
+// parseEvent tries to decode an event at the start of b. It returns
+// the event and the number of bytes used, or 0 bytes if b holds an
+// incomplete sequence. The returned event can be nil if the bytes
+// have to be consumed without reporting anything.
+//
+// Sequence decoding started as a port of terminal.bytesToKey
+func parseEvent(b []byte) (Event, int) {
+	switch {
+	case b[0] == keyEscape:
+		return parseEscape(b)
+	case b[0] < 32 || b[0] == 127:
+		return parseControl(b[0]), 1
+	}
+
+	if !utf8.FullRune(b) {
+		return nil, 0
+	}
+	r, n := utf8.DecodeRune(b)
+	return KeyEvent{Key: KeyRune, Rune: r}, n
+}
+
+// parseKey decodes the first event in b, giving precedence to the
+// key sequences from the terminfo entry over the usual ones
+func (p *inputParser) parseKey(b []byte) (Event, int) {
+	for _, k := range p.keys {
+		if bytes.HasPrefix(b, []byte(k.seq)) {
+			return KeyEvent{Key: k.key}, len(k.seq)
+		}
+	}
+	return parseEvent(b)
+}
+
+// parseControl decodes a single control character. Apart from the
+// ones with their own key, control characters are reported as the
+// letter or symbol pressed along with Ctrl (Ctrl+A is 'a' with
+// ModCtrl, Ctrl+Space is ' ' with ModCtrl, and so on). Note
+// that Ctrl+H is indistinguishable from Backspace in terminals
+// that send ^H for it, but most modern terminals send DEL.
+func parseControl(c byte) Event {
+	switch c {
+	case '\r':
+		return KeyEvent{Key: KeyEnter}
+	case '\t':
+		return KeyEvent{Key: KeyTab}
+	case 127:
+		return KeyEvent{Key: KeyBackspace}
+	case 0:
+		return KeyEvent{Key: KeyRune, Rune: ' ', Mod: ModCtrl}
+	}
+	if c <= 26 {
+		return KeyEvent{Key: KeyRune, Rune: rune('a' + c - 1), Mod: ModCtrl}
+	}
+	// 28-31 are Ctrl+\, Ctrl+], Ctrl+^ and Ctrl+_
+	return KeyEvent{Key: KeyRune, Rune: rune('@' + c), Mod: ModCtrl}
+}
+
+// withMod returns ev with mod added to its modifiers, if it's a KeyEvent
+func withMod(ev Event, mod Modifier) Event {
+	if k, ok := ev.(KeyEvent); ok {
+		k.Mod |= mod
+		return k
+	}
+	return ev
+}
+
+// parseEscape decodes a sequence starting with ESC
+func parseEscape(b []byte) (Event, int) {
+	if len(b) < 2 {
+		return nil, 0
+	}
+	switch b[1] {
+	case '[':
+		return parseCSI(b)
+	case 'O':
+		return parseSS3(b)
+	}
+
+	// Anything else is a key pressed along with Alt, which the
+	// terminal reports by sending ESC before the key itself
+	ev, n := parseEvent(b[1:])
+	if n == 0 {
+		return nil, 0
+	}
+	return withMod(ev, ModAlt), n + 1
+}
+
+// parseCSI decodes a sequence starting with ESC [
+func parseCSI(b []byte) (Event, int) {
+	if len(b) < 3 {
+		return nil, 0
+	}
+	switch b[2] {
+	case 'M':
+		// Legacy mouse events carry three raw bytes after the M
+		if len(b) < 6 {
+			return nil, 0
+		}
+		return parseX10Mouse(b[3:6]), 6
+	case '[':
+		// The Linux console sends ESC [ [ A to ESC [ [ E for F1-F5
+		if len(b) < 4 {
+			return nil, 0
+		}
+		if b[3] >= 'A' && b[3] <= 'E' {
+			return KeyEvent{Key: KeyF1 + Key(b[3]-'A')}, 4
+		}
+		return KeyEvent{Key: KeyUnknown}, 4
+	}
+
+	// Parameter and intermediate bytes are in the 0x20-0x3f
+	// range, and the sequence ends with a byte in 0x40-0x7e
+	for i := 2; i < len(b); i++ {
+		c := b[i]
+		if c >= 0x40 && c <= 0x7e {
+			return csiEvent(string(b[2:i]), c), i + 1
+		}
+		if c < 0x20 || c > 0x7e {
+			// Broken sequence. Drop what we got so far
+			return KeyEvent{Key: KeyUnknown}, i
+		}
+	}
+	return nil, 0
+}
+
+// csiFields splits the parameters of a CSI sequence into fields
+// separated by ';', each one holding sub-parameters separated by ':'.
+// Missing values are returned as 0, and invalid ones as -1.
+func csiFields(params string) [][]int {
+	if params == "" {
+		return nil
+	}
+	var result [][]int
+	for _, f := range strings.Split(params, ";") {
+		var field []int
+		for _, sub := range strings.Split(f, ":") {
+			n, err := strconv.Atoi(sub)
+			switch {
+			case sub == "":
+				n = 0
+			case err != nil:
+				n = -1
+			}
+			field = append(field, n)
+		}
+		result = append(result, field)
+	}
+	return result
+}
+
+// csiParams splits the parameters of a CSI sequence, ignoring
+// sub-parameters. Missing parameters are returned as 0, and
+// invalid ones as -1.
+func csiParams(params string) []int {
+	var result []int
+	for _, f := range csiFields(params) {
+		result = append(result, f[0])
+	}
+	return result
+}
+
+// csiEvent returns the event for a complete CSI
+// sequence with the given parameters and final byte
+func csiEvent(params string, final byte) Event {
+	switch {
+	case strings.HasPrefix(params, "<") && (final == 'M' || final == 'm'):
+		return parseSGRMouse(params[1:], final)
+	case strings.HasPrefix(params, "?"):
+		return parseReply(params[1:], final)
+	}
+
+	fields := csiFields(params)
+	if final == 'u' {
+		return parseKittyKey(fields)
+	}
+
+	p := csiParams(params)
+
+	// The kitty keyboard protocol adds the event
+	// type as a sub-parameter of the modifiers
+	var mod Modifier
+	var action KeyAction
+	if len(p) > 1 {
+		mod = decodeModifier(p[1])
+		if len(fields[1]) > 1 {
+			action = decodeKeyAction(fields[1][1])
+		}
+	}
+
+	switch final {
+	case 'I', 'O':
+		if params == "" {
+			return FocusEvent{final == 'I'}
+		}
+	case 'Z':
+		return KeyEvent{Key: KeyTab, Mod: mod | ModShift, Action: action}
+	case '~':
+		if params == "200" {
+			return pasteStart{}
+		}
+		if len(p) > 0 {
+			if k, ok := tildeKeys[p[0]]; ok {
+				return KeyEvent{Key: k, Mod: mod, Action: action}
+			}
+		}
+		return KeyEvent{Key: KeyUnknown}
+	}
+
+	if len(p) > 0 && p[0] != 1 {
+		return KeyEvent{Key: KeyUnknown}
+	}
+	if k, ok := letterKeys[final]; ok {
+		return KeyEvent{Key: k, Mod: mod, Action: action}
+	}
+	return KeyEvent{Key: KeyUnknown}
+}
+
+// decodeModifier decodes the modifier parameter of a key sequence,
+// which is 1 plus a bitmask of the modifier keys held down
+func decodeModifier(p int) Modifier {
+	if p < 1 {
+		return 0
+	}
+	m := p - 1
+	mod := Modifier(m) & (ModShift | ModAlt | ModCtrl | ModMeta)
+	if m&32 != 0 {
+		// The kitty keyboard protocol reports Super
+		// as 8, like xterm's Meta, and Meta as 32
+		mod |= ModMeta
+	}
+	return mod
+}
+
+// parseSS3 decodes a sequence starting with ESC O, sent by cursor
+// and keypad keys in application mode, and by F1-F4. Some
+// terminals send a modifier parameter before the final byte.
+func parseSS3(b []byte) (Event, int) {
+	i := 2
+	for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+		i++
+	}
+	if i >= len(b) {
+		return nil, 0
+	}
+	var mod Modifier
+	if i > 2 {
+		n, _ := strconv.Atoi(string(b[2:i]))
+		mod = decodeModifier(n)
+	}
+
+	c := b[i]
+	if k, ok := letterKeys[c]; ok {
+		return KeyEvent{Key: k, Mod: mod}, i + 1
+	}
+	if r, ok := keypadRunes[c]; ok {
+		return KeyEvent{Key: KeyRune, Rune: r, Mod: mod}, i + 1
+	}
+	if c == 'M' {
+		return KeyEvent{Key: KeyEnter, Mod: mod}, i + 1
+	}
+	return KeyEvent{Key: KeyUnknown}, i + 1
+}
+
+// parseX10Mouse decodes the three bytes of a legacy mouse event.
+// This encoding can't report coordinates beyond column 222.
+func parseX10Mouse(b []byte) Event {
+	return mouseEvent(int(b[0])-32, int(b[1])-33, int(b[2])-33, false)
+}
+
+// parseSGRMouse decodes the parameters of an SGR mouse event, sent
+// as CSI < button ; x ; y M for presses and motion, and with a final
+// m for releases
+func parseSGRMouse(params string, final byte) Event {
+	p := csiParams(params)
+	if len(p) != 3 || p[0] < 0 || p[1] < 1 || p[2] < 1 {
+		return KeyEvent{Key: KeyUnknown}
+	}
+	return mouseEvent(p[0], p[1]-1, p[2]-1, final == 'm')
+}
+
+// mouseEvent builds a MouseEvent from the button code sent by the
+// terminal, which holds the button in its low bits, along with
+// flags for modifiers, motion and the wheel
+func mouseEvent(cb, x, y int, release bool) Event {
+	ev := MouseEvent{X: x, Y: y}
+	if cb&4 != 0 {
+		ev.Mod |= ModShift
+	}
+	if cb&8 != 0 {
+		ev.Mod |= ModAlt
+	}
+	if cb&16 != 0 {
+		ev.Mod |= ModCtrl
+	}
+
+	button := cb & 3
+	switch {
+	case cb&64 != 0:
+		ev.Button = MouseWheelUp + MouseButton(button)
+		ev.Action = MousePress
+		return ev
+	case cb&128 != 0:
+		// Extra buttons we don't have names for
+		button = 3
+	}
+
+	if button != 3 {
+		ev.Button = MouseLeft + MouseButton(button)
+	}
+	switch {
+	case cb&32 != 0 && ev.Button != MouseNone:
+		ev.Action = MouseDrag
+	case cb&32 != 0:
+		ev.Action = MouseMove
+	case release || button == 3:
+		// Legacy encoding doesn't tell which button got released
+		ev.Action = MouseRelease
+	default:
+		ev.Action = MousePress
+	}
+	return ev
+}
+
+// incomplete returns true if there's a partial sequence
+// pending, waiting for the rest of its bytes
+func (p *inputParser) incomplete() bool {
+	return len(p.pending) > 0 && !p.pasting
+}
+
+// waitResult tells why an inputSource stopped waiting
+type waitResult int
+
+const (
+	waitReady waitResult = iota
+	waitTimeout
+	waitInterrupted
+)
+
+// inputSource reads from the Screen input, with the ability to
+// wait for input for a limited time, which is needed to tell
+// the escape key apart from the start of an escape sequence
+type inputSource interface {
+	// wait blocks until there's input ready to be read, d elapses
+	// (waiting forever if d is negative), or interrupt is called
+	wait(d time.Duration) (waitResult, error)
+	// read reads the input, blocking until there's some
+	read(p []byte) (int, error)
+	// interrupt makes the current or next wait return early
+	interrupt()
+	close()
+}
+
+// readerInput is the inputSource for plain io.Readers, like
+// pipes or SSH channels. Reads happen in a goroutine, which
+// only reads after a wait asked for input, so nothing is taken
+// from the reader before it's needed.
+type readerInput struct {
+	r       io.Reader
+	request chan struct{}
+	results chan readResult
+	wake    chan struct{}
+
+	// reading is set while there's a read in progress. When it
+	// completes, ready is set, and data and err hold its result
+	// until it gets consumed by read.
+	reading bool
+	ready   bool
+	data    []byte
+	err     error
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+func newReaderInput(r io.Reader) *readerInput {
+	in := &readerInput{
+		r:       r,
+		request: make(chan struct{}, 1),
+		results: make(chan readResult, 1),
+		wake:    make(chan struct{}, 1),
+	}
+	go in.loop()
+	return in
+}
+
+func (in *readerInput) loop() {
+	var buf [256]byte
+	for range in.request {
+		n, err := in.r.Read(buf[:])
+		in.results <- readResult{append([]byte(nil), buf[:n]...), err}
+	}
+}
+
+func (in *readerInput) wait(d time.Duration) (waitResult, error) {
+	if in.ready {
+		return waitReady, nil
+	}
+	if !in.reading {
+		in.request <- struct{}{}
+		in.reading = true
+	}
+	var timeout <-chan time.Time
+	if d >= 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case res := <-in.results:
+		in.reading = false
+		in.ready = true
+		in.data, in.err = res.data, res.err
+		return waitReady, nil
+	case <-timeout:
+		return waitTimeout, nil
+	case <-in.wake:
+		return waitInterrupted, nil
+	}
+}
+
+func (in *readerInput) read(p []byte) (int, error) {
+	for !in.ready {
+		in.wait(-1)
+	}
+	n := copy(p, in.data)
+	in.data = in.data[n:]
+	if len(in.data) > 0 {
+		return n, nil
+	}
+	err := in.err
+	in.ready = false
+	in.data, in.err = nil, nil
+	return n, err
+}
+
+func (in *readerInput) interrupt() {
+	select {
+	case in.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the reading goroutine, once
+// the read in progress (if any) completes
+func (in *readerInput) close() {
+	close(in.request)
+}
+
+// source returns where Screen input has to be read from: the
+// terminal between Init and Stop, when the system supports waiting
+// for it, or the Screen input as a plain io.Reader otherwise.
+// Must be called with s.mu held.
+func (s *Screen) source() inputSource {
+	if s.input != nil {
+		return s.input
+	}
+	if s.plain == nil {
+		s.plain = newReaderInput(s.in)
+	}
+	return s.plain
+}
+
+// ReadEvent reads the next event from the Screen input.
+// It will block until a complete event is available.
+//
+// An escape key press looks just like the start of an escape
+// sequence, so after reading a lone ESC, ReadEvent waits for the
+// rest of the sequence for as long as set with SetEscapeDelay.
+func (s *Screen) ReadEvent() (Event, error) {
+	return s.ReadEventContext(context.Background())
+}
+
+// ReadEventContext is like ReadEvent, but returns ctx.Err() as soon
+// as ctx gets cancelled. While the Screen is paused, it waits until
+// it gets resumed. Input that was already being read when cancelled
+// is not lost, and gets returned by the next call.
+func (s *Screen) ReadEventContext(ctx context.Context) (Event, error) {
+	if done := ctx.Done(); done != nil {
+		finished := make(chan struct{})
+		defer close(finished)
+		go func() {
+			select {
+			case <-done:
+				s.mu.Lock()
+				s.interruptRead()
+				s.cond.Broadcast()
+				s.mu.Unlock()
+			case <-finished:
+			}
+		}()
+	}
+
+	for {
+		ev, err := s.readEvent(ctx)
+		if err != nil || !s.handleReply(ev) {
+			return ev, err
+		}
+	}
+}
+
+// readEvent returns the next decoded event, including
+// replies to queries that are not reported to the user
+func (s *Screen) readEvent(ctx context.Context) (Event, error) {
+	for len(s.events) == 0 {
+		s.mu.Lock()
+		for s.paused && !s.stopped && ctx.Err() == nil {
+			s.cond.Wait()
+		}
+		if err := ctx.Err(); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		if s.stopped {
+			s.mu.Unlock()
+			return nil, ErrStopped
+		}
+		if s.redraw {
+			s.redraw = false
+			s.mu.Unlock()
+			return RedrawEvent{}, nil
+		}
+		src := s.source()
+		s.reading = true
+		delay := time.Duration(-1)
+		waiting := s.parser.incomplete()
+		if waiting {
+			delay = s.escapeDelay
+		}
+		s.mu.Unlock()
+
+		var n int
+		res, err := src.wait(delay)
+		if err == nil && res == waitReady {
+			n, err = src.read(s.inBuf[:])
+		}
+
+		s.mu.Lock()
+		s.reading = false
+		s.cond.Broadcast()
+		s.mu.Unlock()
+
+		if n > 0 {
+			s.events = append(s.events, s.parser.feed(s.inBuf[:n])...)
+		}
+		if res == waitTimeout && waiting {
+			// Nothing else arrived, so whatever
+			// is pending won't get completed
+			s.events = append(s.events, s.parser.flush()...)
+		}
+		if err != nil && len(s.events) == 0 {
+			return nil, err
+		}
+	}
+	ev := s.events[0]
+	s.events = s.events[1:]
+	return ev, nil
+}
+
+// interruptRead makes a blocked read of the Screen
+// input return right away. Must be called with s.mu held.
+func (s *Screen) interruptRead() {
+	if !s.reading {
+		return
+	}
+	if s.input != nil {
+		s.input.interrupt()
+	}
+	if s.plain != nil {
+		s.plain.interrupt()
+	}
+}
+
+// RunEventLoop keeps reading events from the Screen input, and
+// sending them through eventChan, until ctx gets cancelled or
+// reading fails. If the Screen input is a terminal, a ResizeEvent
+// is also sent each time its window changes size. It always
+// returns a non-nil error, which is ctx.Err() on cancellation.
+func (s *Screen) RunEventLoop(ctx context.Context, eventChan chan<- Event) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if s.fd() >= 0 {
+		sig := make(chan os.Signal, 1)
+		notifyResize(sig)
+		defer signal.Stop(sig)
+		go s.watchResize(ctx, sig, eventChan)
+	}
+	for {
+		ev, err := s.ReadEventContext(ctx)
+		if err != nil {
+			return err
+		}
+		select {
+		case eventChan <- ev:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// StartEventReadLoop runs a goroutine that keeps
+// reading events from the Screen input until Stop.
+// It returns events through the eventChan param, and
+// errors through the errChan parameter. If the Screen
+// input is a terminal, a ResizeEvent is also sent each
+// time its window changes size.
+func (s *Screen) StartEventReadLoop(eventChan chan<- Event, errChan chan<- error) {
+	go func() {
+		errChan <- s.RunEventLoop(context.Background(), eventChan)
+	}()
+}
+
+// watchResize sends a ResizeEvent through eventChan each time
+// sig reports the terminal window changed size, until ctx is done
+func (s *Screen) watchResize(ctx context.Context, sig <-chan os.Signal, eventChan chan<- Event) {
+	for {
+		select {
+		case <-sig:
+			if w, h, err := s.Size(); err == nil {
+				select {
+				case eventChan <- ResizeEvent{w, h}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build darwin || dragonfly || netbsd || openbsd
+// +build darwin dragonfly netbsd openbsd
+
+package termo
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// selectRead waits until one of the file descriptors in r is
+// ready for reading, or the timeout expires
+func selectRead(nfd int, r *syscall.FdSet, timeout *syscall.Timeval) error {
+	return syscall.Select(nfd, r, nil, nil, timeout)
+}
+
+// FdSet holds one bit per file descriptor in Bits, but the
+// type of its words is different in each system
+
+// fdSetWord is the number of bits in each word of Bits
+const fdSetWord = int(8 * unsafe.Sizeof(syscall.FdSet{}.Bits[0]))
+
+// fdSetSize is the number of file descriptors FdSet can hold
+const fdSetSize = int(8 * unsafe.Sizeof(syscall.FdSet{}.Bits))
+
+func fdSetAdd(set *syscall.FdSet, fd int) {
+	set.Bits[fd/fdSetWord] |= 1 << uint(fd%fdSetWord)
+}
+
+func fdSetHas(set *syscall.FdSet, fd int) bool {
+	return set.Bits[fd/fdSetWord]&(1<<uint(fd%fdSetWord)) != 0
+}
@@ -0,0 +1,28 @@
+package termo
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// selectRead waits until one of the file descriptors in r is
+// ready for reading, or the timeout expires
+func selectRead(nfd int, r *syscall.FdSet, timeout *syscall.Timeval) error {
+	return syscall.Select(nfd, r, nil, nil, timeout)
+}
+
+// FreeBSD names the FdSet bits X__fds_bits instead of Bits
+
+// fdSetWord is the number of bits in each word of X__fds_bits
+const fdSetWord = int(8 * unsafe.Sizeof(syscall.FdSet{}.X__fds_bits[0]))
+
+// fdSetSize is the number of file descriptors FdSet can hold
+const fdSetSize = int(8 * unsafe.Sizeof(syscall.FdSet{}.X__fds_bits))
+
+func fdSetAdd(set *syscall.FdSet, fd int) {
+	set.X__fds_bits[fd/fdSetWord] |= 1 << uint(fd%fdSetWord)
+}
+
+func fdSetHas(set *syscall.FdSet, fd int) bool {
+	return set.X__fds_bits[fd/fdSetWord]&(1<<uint(fd%fdSetWord)) != 0
+}
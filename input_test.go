@@ -0,0 +1,339 @@
+package termo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// parseInput feeds in to a parser in chunks of bytesPerRead
+// bytes, and returns all the events it produced
+func parseInput(in string, bytesPerRead int) []Event {
+	var p inputParser
+	var events []Event
+	b := []byte(in)
+	for len(b) > 0 {
+		n := bytesPerRead
+		if n > len(b) {
+			n = len(b)
+		}
+		events = append(events, p.feed(b[:n])...)
+		b = b[n:]
+	}
+	return append(events, p.flush()...)
+}
+
+var inputTests = []struct {
+	in     string
+	events []Event
+}{
+	{
+		in:     "a",
+		events: []Event{KeyEvent{Key: KeyRune, Rune: 'a'}},
+	},
+	{
+		in: "ab\r",
+		events: []Event{
+			KeyEvent{Key: KeyRune, Rune: 'a'},
+			KeyEvent{Key: KeyRune, Rune: 'b'},
+			KeyEvent{Key: KeyEnter},
+		},
+	},
+	{
+		in: "Ξε",
+		events: []Event{
+			KeyEvent{Key: KeyRune, Rune: 'Ξ'},
+			KeyEvent{Key: KeyRune, Rune: 'ε'},
+		},
+	},
+	{
+		in: "\x1b[A\x1b[Dx", // up, left
+		events: []Event{
+			KeyEvent{Key: KeyUp},
+			KeyEvent{Key: KeyLeft},
+			KeyEvent{Key: KeyRune, Rune: 'x'},
+		},
+	},
+	{
+		in:     "\x1b[1;3C", // alt+right
+		events: []Event{KeyEvent{Key: KeyRight, Mod: ModAlt}},
+	},
+	{
+		in:     "\x1bOH",
+		events: []Event{KeyEvent{Key: KeyHome}},
+	},
+	{
+		in: "\x1bOA\x1bOD", // up, left in application mode
+		events: []Event{
+			KeyEvent{Key: KeyUp},
+			KeyEvent{Key: KeyLeft},
+		},
+	},
+	{
+		in: "\x1b[5~\x1b[6~\x1b[2~\x1b[3~", // pgup, pgdn, insert, delete
+		events: []Event{
+			KeyEvent{Key: KeyPgUp},
+			KeyEvent{Key: KeyPgDn},
+			KeyEvent{Key: KeyInsert},
+			KeyEvent{Key: KeyDelete},
+		},
+	},
+	{
+		in: "\x1bOP\x1b[15~\x1b[24~\x1b[[B", // F1, F5, F12, F2 in the Linux console
+		events: []Event{
+			KeyEvent{Key: KeyF1},
+			KeyEvent{Key: KeyF5},
+			KeyEvent{Key: KeyF12},
+			KeyEvent{Key: KeyF2},
+		},
+	},
+	{
+		in: "\x1b[H\x1b[4~\x1bOq\x1bOM", // home, end, keypad 1, keypad enter
+		events: []Event{
+			KeyEvent{Key: KeyHome},
+			KeyEvent{Key: KeyEnd},
+			KeyEvent{Key: KeyRune, Rune: '1'},
+			KeyEvent{Key: KeyEnter},
+		},
+	},
+	{
+		in: "\x1b[1;5C\x1b[1;2A\x1b[15;5~\x1bO5P", // ctrl+right, shift+up, ctrl+F5, ctrl+F1
+		events: []Event{
+			KeyEvent{Key: KeyRight, Mod: ModCtrl},
+			KeyEvent{Key: KeyUp, Mod: ModShift},
+			KeyEvent{Key: KeyF5, Mod: ModCtrl},
+			KeyEvent{Key: KeyF1, Mod: ModCtrl},
+		},
+	},
+	{
+		in: "\x1b[1;8D\x1b[Z", // ctrl+alt+shift+left, shift+tab
+		events: []Event{
+			KeyEvent{Key: KeyLeft, Mod: ModCtrl | ModAlt | ModShift},
+			KeyEvent{Key: KeyTab, Mod: ModShift},
+		},
+	},
+	{
+		in: "\x01\x1a\x00\x1f\x7f", // ctrl+a, ctrl+z, ctrl+space, ctrl+_, backspace
+		events: []Event{
+			KeyEvent{Key: KeyRune, Rune: 'a', Mod: ModCtrl},
+			KeyEvent{Key: KeyRune, Rune: 'z', Mod: ModCtrl},
+			KeyEvent{Key: KeyRune, Rune: ' ', Mod: ModCtrl},
+			KeyEvent{Key: KeyRune, Rune: '_', Mod: ModCtrl},
+			KeyEvent{Key: KeyBackspace},
+		},
+	},
+	{
+		in: "\x1bx\x1b\x02\x1bé\x1b\x1b[A", // alt+x, alt+ctrl+b, alt+é, alt+up
+		events: []Event{
+			KeyEvent{Key: KeyRune, Rune: 'x', Mod: ModAlt},
+			KeyEvent{Key: KeyRune, Rune: 'b', Mod: ModAlt | ModCtrl},
+			KeyEvent{Key: KeyRune, Rune: 'é', Mod: ModAlt},
+			KeyEvent{Key: KeyUp, Mod: ModAlt},
+		},
+	},
+	{
+		in:     "\x1b",
+		events: []Event{KeyEvent{Key: KeyEscape}},
+	},
+	{
+		in: "\x1b[M #$\x1b[M#*+", // left press at [2,3], release at [9,10]
+		events: []Event{
+			MouseEvent{X: 2, Y: 3, Button: MouseLeft, Action: MousePress},
+			MouseEvent{X: 9, Y: 10, Action: MouseRelease},
+		},
+	},
+	{
+		in: "\x1b[<0;300;20M\x1b[<32;301;20M\x1b[<0;301;20m", // left press, drag and release
+		events: []Event{
+			MouseEvent{X: 299, Y: 19, Button: MouseLeft, Action: MousePress},
+			MouseEvent{X: 300, Y: 19, Button: MouseLeft, Action: MouseDrag},
+			MouseEvent{X: 300, Y: 19, Button: MouseLeft, Action: MouseRelease},
+		},
+	},
+	{
+		in: "\x1b[<65;1;2M\x1b[<18;5;5M\x1b[<35;3;3M", // wheel down, ctrl+right press, move
+		events: []Event{
+			MouseEvent{X: 0, Y: 1, Button: MouseWheelDown, Action: MousePress},
+			MouseEvent{X: 4, Y: 4, Button: MouseRight, Action: MousePress, Mod: ModCtrl},
+			MouseEvent{X: 2, Y: 2, Action: MouseMove},
+		},
+	},
+	{
+		in: "a\x1b[200~x\x1b[A\ry\x1b[201~b", // paste with an escape sequence in it
+		events: []Event{
+			KeyEvent{Key: KeyRune, Rune: 'a'},
+			PasteEvent{"x\x1b[A\ny"},
+			KeyEvent{Key: KeyRune, Rune: 'b'},
+		},
+	},
+	{
+		in: "\x1b[O\x1b[I", // focus out, focus in
+		events: []Event{
+			FocusEvent{false},
+			FocusEvent{true},
+		},
+	},
+	{
+		in: "\x1b[105;5u\x1b[9u\x1b[27u", // kitty ctrl+i, tab, escape
+		events: []Event{
+			KeyEvent{Key: KeyRune, Rune: 'i', Mod: ModCtrl},
+			KeyEvent{Key: KeyTab},
+			KeyEvent{Key: KeyEscape},
+		},
+	},
+	{
+		in: "\x1b[97;1:2u\x1b[97;1:3u\x1b[97:65;2u\x1b[1;5:3C", // kitty repeat, release, shift+a, ctrl+right release
+		events: []Event{
+			KeyEvent{Key: KeyRune, Rune: 'a', Action: KeyRepeat},
+			KeyEvent{Key: KeyRune, Rune: 'a', Action: KeyRelease},
+			KeyEvent{Key: KeyRune, Rune: 'A'},
+			KeyEvent{Key: KeyRight, Mod: ModCtrl, Action: KeyRelease},
+		},
+	},
+	{
+		in: "\x1b[99zq", // unknown sequence
+		events: []Event{
+			KeyEvent{Key: KeyUnknown},
+			KeyEvent{Key: KeyRune, Rune: 'q'},
+		},
+	},
+}
+
+func TestInputParser(t *testing.T) {
+	for i, test := range inputTests {
+		for j := 1; j <= len(test.in); j++ {
+			events := parseInput(test.in, j)
+			if !reflect.DeepEqual(events, test.events) {
+				t.Errorf("Events from test %d (%d bytes per read) were %v, expected %v", i, j, events, test.events)
+				break
+			}
+		}
+	}
+}
+
+// withPipeInput runs f with a Screen reading from a pipe, first
+// as a plain io.Reader, and then as a terminal file descriptor if
+// the system supports waiting for those
+func withPipeInput(t *testing.T, f func(s *Screen, w io.Writer)) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	f(NewScreen(pr, &bytes.Buffer{}), pw)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	s := NewScreen(r, &bytes.Buffer{})
+	if s.input, err = openInput(int(r.Fd())); err != nil {
+		return
+	}
+	defer s.input.close()
+	f(s, w)
+}
+
+func TestEscapeDelay(t *testing.T) {
+	withPipeInput(t, func(s *Screen, w io.Writer) {
+		s.SetEscapeDelay(50 * time.Millisecond)
+
+		// The rest of the sequence arrives in time
+		go func() {
+			w.Write([]byte("\x1b"))
+			time.Sleep(10 * time.Millisecond)
+			w.Write([]byte("[A"))
+		}()
+		ev, err := s.ReadEvent()
+		if err != nil || ev != (KeyEvent{Key: KeyUp}) {
+			t.Fatalf("Got %v, %v, expected up key", ev, err)
+		}
+
+		// Nothing else arrives, so it's the escape key
+		go w.Write([]byte("\x1b"))
+		ev, err = s.ReadEvent()
+		if err != nil || ev != (KeyEvent{Key: KeyEscape}) {
+			t.Fatalf("Got %v, %v, expected escape key", ev, err)
+		}
+	})
+}
+
+func TestReadEventCancel(t *testing.T) {
+	withPipeInput(t, func(s *Screen, w io.Writer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		if _, err := s.ReadEventContext(ctx); err != context.Canceled {
+			t.Fatalf("Got error %v after cancelling, expected %v", err, context.Canceled)
+		}
+
+		// Input is not lost after a cancelled read
+		go w.Write([]byte("a"))
+		ev, err := s.ReadEvent()
+		if err != nil || ev != (KeyEvent{Key: KeyRune, Rune: 'a'}) {
+			t.Fatalf("Got %v, %v, expected 'a' key", ev, err)
+		}
+	})
+}
+
+func TestKittyKeyboardNegotiation(t *testing.T) {
+	for _, supported := range []bool{false, true} {
+		in := "\x1b[?62;22cx"
+		if supported {
+			in = "\x1b[?0u" + in
+		}
+		var out bytes.Buffer
+		s := NewScreen(bytes.NewBufferString(in), &out)
+		s.EnableKittyKeyboard(KittyDisambiguate | KittyReportEvents)
+		ev, err := s.ReadEvent()
+		if err != nil || ev != (KeyEvent{Key: KeyRune, Rune: 'x'}) {
+			t.Fatalf("Got %v, %v, expected 'x' key", ev, err)
+		}
+		if s.KittyKeyboardActive() != supported {
+			t.Fatalf("Kitty keyboard active is %v, expected %v", s.KittyKeyboardActive(), supported)
+		}
+		want := "\x1b[?u\x1b[c"
+		if supported {
+			want += "\x1b[>3u"
+		}
+		if out.String() != want {
+			t.Fatalf("Negotiation wrote %q, expected %q", out.String(), want)
+		}
+	}
+}
+
+func TestKittyKeyboardChanges(t *testing.T) {
+	var out bytes.Buffer
+	s := NewScreen(bytes.NewBufferString("\x1b[?0u\x1b[?62;22cx"), &out)
+	s.EnableKittyKeyboard(KittyDisambiguate)
+	if _, err := s.ReadEvent(); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+
+	// New flags replace the active ones without querying again
+	s.EnableKittyKeyboard(KittyDisambiguate | KittyReportAllKeys)
+	if want := "\x1b[=9;1u"; out.String() != want {
+		t.Fatalf("changing flags wrote %q, expected %q", out.String(), want)
+	}
+
+	// Pause turns it off for child processes, and Resume back on
+	out.Reset()
+	s.Pause()
+	if s.KittyKeyboardActive() {
+		t.Fatal("Kitty keyboard still active after Pause")
+	}
+	s.EnableKittyKeyboard(KittyDisambiguate)
+	s.Resume()
+	if !s.KittyKeyboardActive() {
+		t.Fatal("Kitty keyboard not active after Resume")
+	}
+	if want := "\x1b[<u\x1b[>1u"; out.String() != want {
+		t.Fatalf("pausing and resuming wrote %q, expected %q", out.String(), want)
+	}
+}
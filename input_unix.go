@@ -0,0 +1,109 @@
+//go:build !windows
+// +build !windows
+
+package termo
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+)
+
+// ttyInput reads from a terminal file descriptor, waiting for input
+// with select. Unlike read deadlines, this doesn't need the descriptor
+// to be non-blocking, which would also affect output going to the same
+// terminal, and the shell if the program died without calling Stop.
+// Waits are interrupted by writing to a pipe that's selected as well.
+type ttyInput struct {
+	fd   int
+	wake [2]int
+}
+
+// openInput returns an input source reading from the terminal
+// file descriptor fd, which must be closed when done
+func openInput(fd int) (inputSource, error) {
+	if fd >= fdSetSize {
+		return nil, errors.New("file descriptor too large for select")
+	}
+	in := &ttyInput{fd: fd}
+	if err := syscall.Pipe(in.wake[:]); err != nil {
+		return nil, err
+	}
+	if in.wake[0] >= fdSetSize {
+		in.close()
+		return nil, errors.New("file descriptor too large for select")
+	}
+	for _, p := range in.wake {
+		syscall.CloseOnExec(p)
+		if err := syscall.SetNonblock(p, true); err != nil {
+			in.close()
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+func (in *ttyInput) wait(d time.Duration) (waitResult, error) {
+	deadline := time.Now().Add(d)
+	for {
+		var set syscall.FdSet
+		fdSetAdd(&set, in.fd)
+		fdSetAdd(&set, in.wake[0])
+		var tv *syscall.Timeval
+		if d >= 0 {
+			left := time.Until(deadline)
+			if left < 0 {
+				left = 0
+			}
+			t := syscall.NsecToTimeval(int64(left))
+			tv = &t
+		}
+		nfd := in.fd
+		if in.wake[0] > nfd {
+			nfd = in.wake[0]
+		}
+		err := selectRead(nfd+1, &set, tv)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err != nil:
+			return waitInterrupted, err
+		case fdSetHas(&set, in.wake[0]):
+			var b [16]byte
+			for {
+				if n, _ := syscall.Read(in.wake[0], b[:]); n <= 0 {
+					break
+				}
+			}
+			return waitInterrupted, nil
+		case fdSetHas(&set, in.fd):
+			return waitReady, nil
+		}
+		return waitTimeout, nil
+	}
+}
+
+func (in *ttyInput) read(p []byte) (int, error) {
+	for {
+		n, err := syscall.Read(in.fd, p)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err != nil:
+			return 0, err
+		case n == 0:
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+}
+
+func (in *ttyInput) interrupt() {
+	syscall.Write(in.wake[1], []byte{0})
+}
+
+func (in *ttyInput) close() {
+	syscall.Close(in.wake[0])
+	syscall.Close(in.wake[1])
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package termo
+
+import "errors"
+
+// openInput is not supported on Windows, where
+// the console is read like any other io.Reader
+func openInput(fd int) (inputSource, error) {
+	return nil, errors.New("waiting for console input not supported")
+}
@@ -0,0 +1,182 @@
+package termo
+
+// Key identifies a key on the keyboard
+type Key int
+
+// Keys that can be reported by key events. Both the normal
+// and application cursor/keypad modes of xterm and VT220
+// style terminals are decoded into these.
+const (
+	// KeyRune is reported for keys that produce text. The
+	// actual character is stored in KeyEvent.Rune
+	KeyRune Key = iota
+	KeyUnknown
+	KeyEnter
+	KeyTab
+	KeyBackspace
+	KeyEscape
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyPgUp
+	KeyPgDn
+	KeyInsert
+	KeyDelete
+	// KeyBegin is the center key in the keypad (5 with numlock off)
+	KeyBegin
+	KeyF1
+	KeyF2
+	KeyF3
+	KeyF4
+	KeyF5
+	KeyF6
+	KeyF7
+	KeyF8
+	KeyF9
+	KeyF10
+	KeyF11
+	KeyF12
+	KeyF13
+	KeyF14
+	KeyF15
+	KeyF16
+	KeyF17
+	KeyF18
+	KeyF19
+	KeyF20
+)
+
+var keyNames = []string{
+	KeyRune:      "Rune",
+	KeyUnknown:   "Unknown",
+	KeyEnter:     "Enter",
+	KeyTab:       "Tab",
+	KeyBackspace: "Backspace",
+	KeyEscape:    "Escape",
+	KeyUp:        "Up",
+	KeyDown:      "Down",
+	KeyLeft:      "Left",
+	KeyRight:     "Right",
+	KeyHome:      "Home",
+	KeyEnd:       "End",
+	KeyPgUp:      "PgUp",
+	KeyPgDn:      "PgDn",
+	KeyInsert:    "Insert",
+	KeyDelete:    "Delete",
+	KeyBegin:     "Begin",
+	KeyF1:        "F1",
+	KeyF2:        "F2",
+	KeyF3:        "F3",
+	KeyF4:        "F4",
+	KeyF5:        "F5",
+	KeyF6:        "F6",
+	KeyF7:        "F7",
+	KeyF8:        "F8",
+	KeyF9:        "F9",
+	KeyF10:       "F10",
+	KeyF11:       "F11",
+	KeyF12:       "F12",
+	KeyF13:       "F13",
+	KeyF14:       "F14",
+	KeyF15:       "F15",
+	KeyF16:       "F16",
+	KeyF17:       "F17",
+	KeyF18:       "F18",
+	KeyF19:       "F19",
+	KeyF20:       "F20",
+}
+
+// String returns the name of the key, like "PgUp" or "F5"
+func (k Key) String() string {
+	if k < 0 || int(k) >= len(keyNames) {
+		return "Unknown"
+	}
+	return keyNames[k]
+}
+
+// tildeKeys maps the number in CSI <n> ~ sequences to
+// the corresponding key (VT220 editing and function keys)
+var tildeKeys = map[int]Key{
+	1:  KeyHome,
+	2:  KeyInsert,
+	3:  KeyDelete,
+	4:  KeyEnd,
+	5:  KeyPgUp,
+	6:  KeyPgDn,
+	7:  KeyHome, // rxvt
+	8:  KeyEnd,  // rxvt
+	11: KeyF1,
+	12: KeyF2,
+	13: KeyF3,
+	14: KeyF4,
+	15: KeyF5,
+	17: KeyF6,
+	18: KeyF7,
+	19: KeyF8,
+	20: KeyF9,
+	21: KeyF10,
+	23: KeyF11,
+	24: KeyF12,
+	25: KeyF13,
+	26: KeyF14,
+	28: KeyF15,
+	29: KeyF16,
+	31: KeyF17,
+	32: KeyF18,
+	33: KeyF19,
+	34: KeyF20,
+}
+
+// letterKeys maps the final letter of CSI and SS3 sequences to the
+// corresponding key. CSI is used in normal cursor mode, and SS3 in
+// application cursor mode.
+var letterKeys = map[byte]Key{
+	'A': KeyUp,
+	'B': KeyDown,
+	'C': KeyRight,
+	'D': KeyLeft,
+	'E': KeyBegin,
+	'F': KeyEnd,
+	'H': KeyHome,
+	'P': KeyF1,
+	'Q': KeyF2,
+	'R': KeyF3,
+	'S': KeyF4,
+}
+
+// keypadRunes maps the final byte of SS3 sequences sent by
+// the keypad in application mode to the character on the key
+var keypadRunes = map[byte]rune{
+	'X': '=',
+	'j': '*',
+	'k': '+',
+	'l': ',',
+	'm': '-',
+	'n': '.',
+	'o': '/',
+	'p': '0',
+	'q': '1',
+	'r': '2',
+	's': '3',
+	't': '4',
+	'u': '5',
+	'v': '6',
+	'w': '7',
+	'x': '8',
+	'y': '9',
+}
+
+// Modifier holds the set of modifier keys held
+// down while a key was pressed
+type Modifier uint8
+
+// Modifier keys. They can be combined, like ModCtrl|ModShift.
+const (
+	ModShift Modifier = 1 << iota
+	ModAlt
+	ModCtrl
+	ModMeta
+)
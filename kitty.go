@@ -0,0 +1,266 @@
+package termo
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// KittyFlags selects the enhancements requested from terminals
+// supporting the kitty keyboard protocol. They can be combined.
+// See https://sw.kovidgoyal.net/kitty/keyboard-protocol/
+type KittyFlags int
+
+// Kitty keyboard protocol enhancements
+const (
+	// KittyDisambiguate makes keys that are ambiguous in legacy
+	// encodings (like Ctrl+I and Tab, or Escape) distinguishable
+	KittyDisambiguate KittyFlags = 1
+	// KittyReportEvents also reports key repeats and releases
+	KittyReportEvents KittyFlags = 2
+	// KittyReportAlternates reports the shifted version of
+	// keys, so Rune holds the right character with Shift
+	KittyReportAlternates KittyFlags = 4
+	// KittyReportAllKeys reports all keys as escape
+	// sequences, including text keys
+	KittyReportAllKeys KittyFlags = 8
+	// KittyReportText reports the text produced by keys
+	KittyReportText KittyFlags = 16
+)
+
+// States of the kitty keyboard protocol negotiation
+const (
+	kittyOff = iota
+	kittyQuerying
+	kittyActive
+)
+
+// kittyReply is returned by parseEvent for the answer to the
+// kitty keyboard protocol query, carrying the current flags
+type kittyReply struct {
+	flags int
+}
+
+// deviceAttributes is returned by parseEvent for the answer
+// to the primary device attributes query
+type deviceAttributes struct{}
+
+func (kittyReply) isEvent()       {}
+func (deviceAttributes) isEvent() {}
+
+// parseReply decodes the CSI ? sequences terminals
+// send as replies to queries
+func parseReply(params string, final byte) Event {
+	switch final {
+	case 'u':
+		p := csiParams(params)
+		if len(p) == 1 {
+			return kittyReply{p[0]}
+		}
+	case 'c':
+		return deviceAttributes{}
+	}
+	return KeyEvent{Key: KeyUnknown}
+}
+
+// kittyKeys maps the key codes used by the kitty
+// keyboard protocol to the corresponding keys
+var kittyKeys = map[int]Key{
+	9:     KeyTab,
+	13:    KeyEnter,
+	27:    KeyEscape,
+	127:   KeyBackspace,
+	57376: KeyF13,
+	57377: KeyF14,
+	57378: KeyF15,
+	57379: KeyF16,
+	57380: KeyF17,
+	57381: KeyF18,
+	57382: KeyF19,
+	57383: KeyF20,
+	57414: KeyEnter, // Keypad
+	57417: KeyLeft,
+	57418: KeyRight,
+	57419: KeyUp,
+	57420: KeyDown,
+	57421: KeyPgUp,
+	57422: KeyPgDn,
+	57423: KeyHome,
+	57424: KeyEnd,
+	57425: KeyInsert,
+	57426: KeyDelete,
+	57427: KeyBegin,
+}
+
+// kittyKeypadRunes maps the key codes for keypad keys that
+// produce text to the character on the key
+var kittyKeypadRunes = map[int]rune{
+	57399: '0',
+	57400: '1',
+	57401: '2',
+	57402: '3',
+	57403: '4',
+	57404: '5',
+	57405: '6',
+	57406: '7',
+	57407: '8',
+	57408: '9',
+	57409: '.',
+	57410: '/',
+	57411: '*',
+	57412: '-',
+	57413: '+',
+	57415: '=',
+	57416: ',',
+}
+
+// decodeKeyAction decodes the event type sub-parameter
+// of the kitty keyboard protocol
+func decodeKeyAction(p int) KeyAction {
+	switch p {
+	case 2:
+		return KeyRepeat
+	case 3:
+		return KeyRelease
+	}
+	return KeyPress
+}
+
+// parseKittyKey decodes a CSI key-code:shifted-key ; modifiers:event ;
+// text u sequence from the kitty keyboard protocol
+func parseKittyKey(fields [][]int) Event {
+	if len(fields) == 0 || fields[0][0] < 0 {
+		return KeyEvent{Key: KeyUnknown}
+	}
+	var ev KeyEvent
+	if len(fields) > 1 {
+		ev.Mod = decodeModifier(fields[1][0])
+		if len(fields[1]) > 1 {
+			ev.Action = decodeKeyAction(fields[1][1])
+		}
+	}
+
+	code := fields[0][0]
+	if k, ok := kittyKeys[code]; ok {
+		ev.Key = k
+		return ev
+	}
+	if r, ok := kittyKeypadRunes[code]; ok {
+		ev.Key = KeyRune
+		ev.Rune = r
+		return ev
+	}
+	if code >= 57344 && code <= 63743 {
+		// Other keys in the private use area, like
+		// media keys or lone modifier keys
+		ev.Key = KeyUnknown
+		return ev
+	}
+
+	ev.Key = KeyRune
+	ev.Rune = rune(code)
+	switch {
+	case len(fields) > 2 && fields[2][0] > 0:
+		// The text produced by the key
+		ev.Rune = rune(fields[2][0])
+	case ev.Mod&ModShift != 0 && len(fields[0]) > 1 && fields[0][1] > 0:
+		// The shifted version of the key
+		ev.Rune = rune(fields[0][1])
+	case ev.Mod&ModShift != 0 && ev.Rune < unicode.MaxASCII && unicode.IsLetter(ev.Rune):
+		ev.Rune = unicode.ToUpper(ev.Rune)
+	default:
+		return ev
+	}
+	// Shift is already part of the rune
+	ev.Mod &^= ModShift
+	return ev
+}
+
+// EnableKittyKeyboard asks the terminal to use the kitty keyboard
+// protocol with the given enhancements, if it supports it. Support
+// is queried, and the protocol only gets enabled once the reply
+// arrives through the input read loop. Terminals that don't support
+// it just keep using the legacy encodings, which are still decoded.
+// If the protocol is already active, the new enhancements replace
+// the previous ones right away.
+func (s *Screen) EnableKittyKeyboard(flags KittyFlags) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.mu.Lock()
+	s.kittyFlags = flags
+	switch {
+	case s.kitty == kittyActive:
+		s.mu.Unlock()
+		fmt.Fprintf(&s.buf, "\033[=%d;1u", flags)
+		s.write()
+		return
+	case s.paused && s.kittyPaused:
+		// Resume enables it again with the new flags
+		s.mu.Unlock()
+		return
+	case s.kitty == kittyOff:
+		s.kitty = kittyQuerying
+	}
+	s.mu.Unlock()
+	// Query the current flags, followed by the primary device
+	// attributes, which all terminals answer. If the second
+	// reply arrives first, the protocol is not supported.
+	s.buf.WriteString("\033[?u\033[c")
+	s.write()
+}
+
+// KittyKeyboardActive returns true if the terminal
+// is using the kitty keyboard protocol
+func (s *Screen) KittyKeyboardActive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kitty == kittyActive
+}
+
+// handleReply handles the replies from the terminal to queries,
+// returning true if ev was one of them. They are handled right as
+// they are read, which can happen while another goroutine draws,
+// so output goes through the buffer with s.outMu held.
+func (s *Screen) handleReply(ev Event) bool {
+	switch ev.(type) {
+	case kittyReply:
+		s.outMu.Lock()
+		defer s.outMu.Unlock()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.kitty == kittyQuerying {
+			fmt.Fprintf(&s.buf, "\033[>%du", s.kittyFlags)
+			s.write()
+			s.kitty = kittyActive
+		}
+		return true
+	case deviceAttributes:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.kitty == kittyQuerying {
+			s.kitty = kittyOff
+		}
+		return true
+	}
+	return false
+}
+
+// restoreKittyKeyboard enables the kitty keyboard protocol
+// again after disableKittyKeyboard, if it was active
+func (s *Screen) restoreKittyKeyboard(active bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if active {
+		fmt.Fprintf(&s.buf, "\033[>%du", s.kittyFlags)
+		s.kitty = kittyActive
+	}
+}
+
+// disableKittyKeyboard goes back to legacy key encodings
+func (s *Screen) disableKittyKeyboard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kitty == kittyActive {
+		s.buf.WriteString("\033[<u")
+	}
+	s.kitty = kittyOff
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package termo
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyResize makes ch receive a value each
+// time the terminal window changes size
+func notifyResize(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGWINCH)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package termo
+
+import "os"
+
+// notifyResize does nothing on Windows, where there's no
+// signal for the console window changing size
+func notifyResize(ch chan<- os.Signal) {
+}
@@ -0,0 +1,776 @@
+package termo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonvaldes/termo/terminal"
+)
+
+// Control sequences documentation: http://www.xfree86.org/current/ctlseqs.html
+
+// Screen holds everything needed to drive one terminal: where
+// input comes from, where output goes, and the state needed to
+// restore the terminal afterwards. Several Screens can be used
+// at the same time, for example one per SSH session.
+type Screen struct {
+	in  io.Reader
+	out io.Writer
+
+	// input reads from the same terminal as in, but can wait for
+	// input with a timeout. It's only available between Init and
+	// Stop, and only on systems that support it. Otherwise, plain
+	// reads from in in a goroutine.
+	input inputSource
+	plain *readerInput
+
+	// stopMu is held while Stop restores the terminal
+	stopMu sync.Mutex
+
+	// mu protects the fields below, input and plain. cond is
+	// signalled when any of them change, so readers can wait for
+	// resuming, and Pause and Stop can wait for the current read
+	// to finish.
+	mu      sync.Mutex
+	cond    *sync.Cond
+	reading bool
+	paused  bool
+	stopped bool
+
+	// redraw is set when a RedrawEvent has to be reported
+	redraw bool
+
+	// escapeDelay is set through SetEscapeDelay
+	escapeDelay time.Duration
+
+	// w and h hold the size set through SetSize, for
+	// screens whose input is not a terminal
+	w, h int
+
+	// kitty holds the state of the kitty keyboard protocol
+	// negotiation, and kittyFlags the requested enhancements
+	kitty      int
+	kittyFlags KittyFlags
+
+	// termCaps holds the control sequences for the terminal
+	// type, and is loaded on first use (see caps)
+	termCaps *caps
+
+	// outMu is held while writing output, and protects buf, err
+	// and the terminal state below, like modes and the cursor,
+	// so Stop can be called from other goroutines (for example,
+	// on signals) while drawing
+	outMu sync.Mutex
+
+	// buf accumulates output until it's written with a single
+	// Write call, so the terminal doesn't get partially drawn
+	// frames. err holds the first error writing it.
+	buf bytes.Buffer
+	err error
+
+	oldState         *terminal.State
+	cursorX, cursorY int
+	cursorHidden     bool
+	cursorShape      CursorShape
+	cursorColor      Color
+	mouse            MouseMode
+	paste            bool
+	focus            bool
+	keypad           bool
+	altScreen        bool
+
+	// kittyPaused is set when Pause turned off
+	// the kitty keyboard protocol, for Resume
+	kittyPaused bool
+
+	// colorMode tells which colors the terminal can show. Colors
+	// in framebuffers are downgraded to fit it. It's detected
+	// along with termCaps, unless colorModeSet is set.
+	colorMode    ColorMode
+	colorModeSet bool
+
+	// inline is set when drawing in an area below the cursor,
+	// instead of using the whole screen. The area has inlineH
+	// rows, row is the one the cursor is at, and keepInline tells
+	// if the area is left behind by Stop.
+	inline     bool
+	keepInline bool
+	inlineH    int
+	row        int
+
+	// parser decodes the bytes read into inBuf, and events
+	// holds the decoded events not yet returned by ReadEvent
+	parser inputParser
+	inBuf  [256]byte
+	events []Event
+
+	// front holds the cells last pushed to the terminal, so
+	// flushing only needs to repaint what changed since then. A
+	// nil front means the next flush will repaint everything.
+	front *Framebuffer
+}
+
+// NewScreen creates a Screen that reads input from in and writes
+// output to out. If in is a terminal file (like os.Stdin), Init
+// will put it in raw mode and Size will query it. Otherwise, the
+// caller is responsible for raw mode, and must use SetSize to
+// tell the Screen its dimensions.
+func NewScreen(in io.Reader, out io.Writer) *Screen {
+	s := &Screen{in: in, out: out, escapeDelay: DefaultEscapeDelay}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// SetColorMode overrides the colors the terminal is assumed to
+// support, which are otherwise detected with DetectColorMode, or
+// from the terminfo entry if the terminal type is set with SetTerm.
+// Colors not supported are replaced with the closest ones.
+func (s *Screen) SetColorMode(m ColorMode) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.colorMode = m
+	s.colorModeSet = true
+	s.front = nil
+}
+
+// ColorMode returns the colors the terminal is assumed to support
+func (s *Screen) ColorMode() ColorMode {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	// It's detected along with the terminal capabilities
+	s.caps()
+	return s.colorMode
+}
+
+// DefaultEscapeDelay is how long ReadEvent waits after a lone
+// ESC byte before deciding it's the escape key, unless
+// changed with SetEscapeDelay
+const DefaultEscapeDelay = 25 * time.Millisecond
+
+// SetEscapeDelay sets how long ReadEvent waits after reading a lone
+// ESC byte for the rest of an escape sequence, before deciding it's
+// the escape key being pressed. Slow links might need longer delays.
+func (s *Screen) SetEscapeDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.escapeDelay = d
+}
+
+// fd returns the file descriptor of the terminal backing the
+// Screen input, or -1 if it's not a terminal. Other files, like
+// pipes, are read like any other io.Reader.
+func (s *Screen) fd() int {
+	if f, ok := s.in.(interface {
+		Fd() uintptr
+	}); ok && terminal.IsTerminal(int(f.Fd())) {
+		return int(f.Fd())
+	}
+	return -1
+}
+
+// write sends everything accumulated in the output buffer. Writers
+// returning short writes without an error get the rest sent again.
+// The first error is kept, and returned by Err.
+func (s *Screen) write() {
+	for s.buf.Len() > 0 {
+		n, err := s.out.Write(s.buf.Bytes())
+		s.buf.Next(n)
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			if s.err == nil {
+				s.err = err
+			}
+			break
+		}
+	}
+	s.buf.Reset()
+}
+
+// Err returns the first error found writing to the
+// Screen output, or nil if there weren't any
+func (s *Screen) Err() error {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	return s.err
+}
+
+// Init initializes the Screen to work with the terminal
+func (s *Screen) Init() error {
+	s.inline = false
+	return s.init()
+}
+
+// InitInline initializes the Screen to draw framebuffers in an area
+// starting at the row the cursor is at, instead of using the whole
+// screen, leaving the rest of the terminal contents alone. The area
+// takes as many rows as the framebuffer flushed, and the terminal
+// scrolls if there's no room for them below the cursor. If keep is
+// set, Stop leaves the last frame drawn in the terminal, and moves
+// the cursor below it. Otherwise, Stop erases it.
+func (s *Screen) InitInline(keep bool) error {
+	s.inline = true
+	s.keepInline = keep
+	s.inlineH = 0
+	s.row = 0
+	s.ForceFullRedraw()
+	return s.init()
+}
+
+func (s *Screen) init() error {
+	if fd := s.fd(); fd >= 0 {
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		s.oldState = state
+		s.openInput()
+	}
+	s.mu.Lock()
+	s.stopped = false
+	s.paused = false
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	register(s)
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	// Keypad transmit mode makes special keys send
+	// the sequences listed in the terminfo entry
+	s.buf.WriteString(s.caps().smkx)
+	s.keypad = true
+	s.buf.WriteString(s.caps().civis)
+	s.cursorHidden = true
+	s.write()
+	return nil
+}
+
+// openInput opens the terminal input for the Screen, if
+// the system supports it
+func (s *Screen) openInput() {
+	in, err := openInput(s.fd())
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.input = in
+	s.mu.Unlock()
+}
+
+// closeInput interrupts any read in progress, waits for
+// it to finish, and closes the terminal input
+func (s *Screen) closeInput() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interruptRead()
+	for s.reading {
+		s.cond.Wait()
+	}
+	if s.input != nil {
+		s.input.close()
+		s.input = nil
+	}
+}
+
+// Stop restores the terminal to its original state. Any
+// ReadEvent call in progress returns ErrStopped. Calling
+// Stop again does nothing until the next Init.
+func (s *Screen) Stop() {
+	// Only one Stop runs at a time, so one called from a signal
+	// handler finishes before the program exits through another
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	unregister(s)
+	s.closeInput()
+	s.mu.Lock()
+	if s.plain != nil {
+		s.plain.close()
+		s.plain = nil
+	}
+	s.mu.Unlock()
+	if s.oldState != nil {
+		terminal.Restore(s.fd(), s.oldState)
+		s.oldState = nil
+	}
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.buf.WriteString(s.caps().cnorm)
+	s.cursorHidden = false
+	s.setCursorShape(CursorDefault)
+	s.setCursorColor(ColorDefault)
+	if s.keypad {
+		s.buf.WriteString(s.caps().rmkx)
+		s.keypad = false
+	}
+	s.setMouseMode(MouseOff)
+	s.setPrivateMode(2004, false, &s.paste)
+	s.setPrivateMode(1004, false, &s.focus)
+	s.disableKittyKeyboard()
+	s.setAltScreen(false)
+	s.stopInline()
+	s.write()
+}
+
+// stopInline leaves behind or erases the inline drawing area
+func (s *Screen) stopInline() {
+	if !s.inline {
+		return
+	}
+	if s.keepInline {
+		if s.inlineH > 0 {
+			s.moveTo(0, s.inlineH-1)
+			s.buf.WriteString("\r\n")
+		}
+	} else {
+		s.moveTo(0, 0)
+		s.buf.WriteString(s.caps().ed)
+	}
+	s.inline = false
+	s.inlineH = 0
+	s.front = nil
+}
+
+// resizeInline changes the number of rows in the inline drawing
+// area, making room for new ones by scrolling the terminal if needed,
+// and erasing the ones left over
+func (s *Screen) resizeInline(h int) {
+	if h > s.inlineH {
+		if s.inlineH > 0 {
+			s.moveTo(0, s.inlineH-1)
+			s.buf.WriteString(strings.Repeat("\n", h-s.inlineH))
+		} else {
+			s.buf.WriteString("\r" + strings.Repeat("\n", h-1))
+		}
+		s.row = h - 1
+	} else if h < s.inlineH {
+		s.moveTo(0, h)
+		s.buf.WriteString(s.caps().ed)
+	}
+	s.inlineH = h
+}
+
+// Pause stops reading input and restores the terminal to its
+// original mode, so it can be used by something else, like a child
+// process. Any ReadEvent call in progress is interrupted, and will
+// wait until Resume is called. The kitty keyboard protocol is turned
+// off until then. Make sure to also put the terminal in a state the
+// child process expects (for example, by disabling mouse events).
+func (s *Screen) Pause() error {
+	s.mu.Lock()
+	if s.paused {
+		s.mu.Unlock()
+		return nil
+	}
+	s.paused = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.closeInput()
+	s.outMu.Lock()
+	s.kittyPaused = s.KittyKeyboardActive()
+	s.disableKittyKeyboard()
+	s.write()
+	s.outMu.Unlock()
+	if s.oldState != nil {
+		return terminal.Restore(s.fd(), s.oldState)
+	}
+	return nil
+}
+
+// Resume puts the terminal back in raw mode after Pause,
+// and makes input reading continue
+func (s *Screen) Resume() error {
+	s.mu.Lock()
+	paused := s.paused
+	s.mu.Unlock()
+	if !paused {
+		return nil
+	}
+
+	if s.oldState != nil {
+		if _, err := terminal.MakeRaw(s.fd()); err != nil {
+			return err
+		}
+		s.openInput()
+	}
+	s.outMu.Lock()
+	s.restoreKittyKeyboard(s.kittyPaused)
+	s.kittyPaused = false
+	s.write()
+	s.outMu.Unlock()
+	s.mu.Lock()
+	s.paused = false
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return nil
+}
+
+// HideCursor makes the cursor invisible
+func (s *Screen) HideCursor() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.buf.WriteString(s.caps().civis)
+	s.cursorHidden = true
+	s.write()
+}
+
+// ShowCursor makes the cursor visible
+func (s *Screen) ShowCursor() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.buf.WriteString(s.caps().cnorm)
+	s.cursorHidden = false
+	s.write()
+}
+
+// SetCursor positions the cursor at the specified coordinates.
+// Cursor visibility is not affected.
+func (s *Screen) SetCursor(x, y int) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.cursorX = x
+	s.cursorY = y
+	s.moveTo(x, y)
+	s.write()
+}
+
+// CursorShape selects how the cursor looks
+type CursorShape int
+
+// Cursor shapes, as defined by the DECSCUSR sequence
+const (
+	// CursorDefault is the shape the user configured in the terminal
+	CursorDefault CursorShape = iota
+	CursorBlinkingBlock
+	CursorBlock
+	CursorBlinkingUnderline
+	CursorUnderline
+	CursorBlinkingBar
+	CursorBar
+)
+
+// SetCursorShape changes how the cursor looks. Stop
+// restores the shape the user configured.
+func (s *Screen) SetCursorShape(shape CursorShape) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setCursorShape(shape)
+	s.write()
+}
+
+func (s *Screen) setCursorShape(shape CursorShape) {
+	if shape == s.cursorShape {
+		return
+	}
+	s.buf.WriteString(tparm(s.caps().ss, int(shape)))
+	s.cursorShape = shape
+}
+
+// SetCursorColor changes the color of the cursor. Passing
+// ColorDefault restores the color the user configured,
+// which Stop also does.
+func (s *Screen) SetCursorColor(c Color) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setCursorColor(c)
+	s.write()
+}
+
+func (s *Screen) setCursorColor(c Color) {
+	if c == s.cursorColor {
+		return
+	}
+	if c == ColorDefault {
+		s.buf.WriteString("\033]112\007")
+	} else {
+		r, g, b := c.rgb()
+		fmt.Fprintf(&s.buf, "\033]12;#%02x%02x%02x\007", r, g, b)
+	}
+	s.cursorColor = c
+}
+
+// MouseMode selects which mouse events get reported by the terminal
+type MouseMode int
+
+// Mouse tracking levels. Each one reports
+// everything the previous one does.
+const (
+	// MouseOff doesn't report any mouse events
+	MouseOff MouseMode = 0
+	// MouseClicks reports button presses and releases,
+	// and wheel movements
+	MouseClicks MouseMode = 1000
+	// MouseDrags also reports motion while a button is held down
+	MouseDrags MouseMode = 1002
+	// MouseMotion reports all mouse motion
+	MouseMotion MouseMode = 1003
+)
+
+// SetMouseMode selects which mouse events start arriving
+// through the input read loop. The SGR encoding is requested,
+// which supports any terminal size, and terminals that don't
+// support it will fall back to the legacy encoding.
+func (s *Screen) SetMouseMode(m MouseMode) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setMouseMode(m)
+	s.write()
+}
+
+func (s *Screen) setMouseMode(m MouseMode) {
+	if m == s.mouse {
+		return
+	}
+	if s.mouse != MouseOff {
+		fmt.Fprintf(&s.buf, "\033[?1006l\033[?%dl", s.mouse)
+	}
+	if m != MouseOff {
+		fmt.Fprintf(&s.buf, "\033[?%dh\033[?1006h", m)
+	}
+	s.mouse = m
+}
+
+// EnableMouseEvents makes all mouse events, including
+// motion, start arriving through the input read loop
+func (s *Screen) EnableMouseEvents() {
+	s.SetMouseMode(MouseMotion)
+}
+
+// DisableMouseEvents stops mouse events from
+// arriving through the input read loop
+func (s *Screen) DisableMouseEvents() {
+	s.SetMouseMode(MouseOff)
+}
+
+// EnableBracketedPaste makes pasted text arrive through the input
+// read loop as a single PasteEvent, instead of as individual keys
+func (s *Screen) EnableBracketedPaste() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setPrivateMode(2004, true, &s.paste)
+	s.write()
+}
+
+// DisableBracketedPaste makes pasted text
+// arrive as individual keys again
+func (s *Screen) DisableBracketedPaste() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setPrivateMode(2004, false, &s.paste)
+	s.write()
+}
+
+// EnableFocusEvents makes the terminal report a FocusEvent through
+// the input read loop each time its window gains or loses the focus
+func (s *Screen) EnableFocusEvents() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setPrivateMode(1004, true, &s.focus)
+	s.write()
+}
+
+// DisableFocusEvents stops focus events from
+// arriving through the input read loop
+func (s *Screen) DisableFocusEvents() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setPrivateMode(1004, false, &s.focus)
+	s.write()
+}
+
+// EnableAltScreen switches to the terminal's alternate screen, so
+// drawing doesn't overwrite the shell contents and scrollback. They
+// come back when Stop switches to the main screen again, like with
+// less or vim. It's usually called right after Init.
+func (s *Screen) EnableAltScreen() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setAltScreen(true)
+	s.write()
+}
+
+// DisableAltScreen switches back to the main screen,
+// bringing back its contents from before EnableAltScreen
+func (s *Screen) DisableAltScreen() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.setAltScreen(false)
+	s.write()
+}
+
+func (s *Screen) setAltScreen(enable bool) {
+	if enable == s.altScreen {
+		return
+	}
+	if enable {
+		s.buf.WriteString(s.caps().smcup)
+	} else {
+		s.buf.WriteString(s.caps().rmcup)
+	}
+	s.altScreen = enable
+	// The other screen has different contents
+	s.front = nil
+}
+
+// setPrivateMode turns a DEC private mode on or off, if it's not
+// already in that state according to current, which gets updated
+func (s *Screen) setPrivateMode(mode int, enable bool, current *bool) {
+	if enable == *current {
+		return
+	}
+	if enable {
+		fmt.Fprintf(&s.buf, "\033[?%dh", mode)
+	} else {
+		fmt.Fprintf(&s.buf, "\033[?%dl", mode)
+	}
+	*current = enable
+}
+
+// Size returns the current size of the terminal
+func (s *Screen) Size() (int, int, error) {
+	if fd := s.fd(); fd >= 0 {
+		return terminal.GetSize(fd)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == 0 && s.h == 0 {
+		return -1, -1, ErrNotATerminal
+	}
+	return s.w, s.h, nil
+}
+
+// SetSize sets the size reported by Size, for Screens whose
+// input is not a terminal (for example, an SSH channel, where
+// the size arrives through window-change requests)
+func (s *Screen) SetSize(w, h int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w = w
+	s.h = h
+}
+
+// ReadScanCode reads a keypress from the Screen input.
+// It will block until it can read something
+func (s *Screen) ReadScanCode() (ScanCode, error) {
+	sc := ScanCode{0, 0, 0, 0, 0, 0}
+	s.mu.Lock()
+	src := s.source()
+	s.mu.Unlock()
+	_, err := src.read(sc)
+	return sc, err
+}
+
+// StartKeyReadLoop runs a goroutine that
+// keeps reading the Screen input forever.
+// It returns events through the keyChan param, and
+// errors through the errChan parameter
+func (s *Screen) StartKeyReadLoop(keyChan chan<- ScanCode, errChan chan<- error) {
+	go func() {
+		for {
+			sc, err := s.ReadScanCode()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			keyChan <- sc
+		}
+	}()
+}
+
+// NewFramebuffer creates a Framebuffer with the specified size
+// that will be flushed to this Screen
+func (s *Screen) NewFramebuffer(w, h int) *Framebuffer {
+	result := &Framebuffer{w, h, make([]cell, w*h), s}
+	result.Clear()
+	return result
+}
+
+// ForceFullRedraw makes the next Flush repaint every cell, instead
+// of only the ones that changed. Useful when the terminal contents
+// got corrupted by some external program.
+func (s *Screen) ForceFullRedraw() {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.front = nil
+}
+
+// flush pushes the contents of f to the terminal, only
+// sending the cells that changed since the last flush
+func (s *Screen) flush(f *Framebuffer) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	// Detect the color mode if still needed
+	s.caps()
+	mode := s.colorMode
+	full := s.front == nil || s.front.w != f.w || s.front.h != f.h
+	if full {
+		s.front = &Framebuffer{f.w, f.h, make([]cell, len(f.chars)), s}
+		if s.inline {
+			s.resizeInline(f.h)
+		} else {
+			// Get rid of anything outside the framebuffer, like
+			// leftovers from before the terminal got resized
+			s.buf.WriteString(s.caps().clear)
+		}
+	}
+
+	// The previous flush left the terminal with default attributes
+	pen := StateDefault
+
+	for y := 0; y < f.h; y++ {
+		jump := true
+		for x := 0; x < f.w; {
+			i := y*f.w + x
+			n := f.glyphWidth(i)
+			if !full && f.chars[i] == s.front.chars[i] && (n == 1 || f.chars[i+1] == s.front.chars[i+1]) {
+				jump = true
+				x += n
+				continue
+			}
+			// Jump to the start of a run of changed cells, and write
+			// all of them in one go
+			if jump {
+				s.moveTo(x, y)
+				jump = false
+			}
+			c := f.chars[i]
+			state := c.state.downgrade(mode)
+			s.buf.WriteString(s.caps().stateDiff(pen, state))
+			pen = state
+			switch w := runeWidth(c.r); {
+			case c.cont || c.r < 32 || w == 2 && n == 1:
+				// Control characters, and wide characters
+				// without room for their second half
+				s.buf.WriteByte(' ')
+			case w == 0:
+				// Give lone combining marks a base to sit on
+				s.buf.WriteByte(' ')
+				fallthrough
+			default:
+				s.buf.WriteRune(c.r)
+				s.buf.WriteString(c.comb)
+			}
+			copy(s.front.chars[i:i+n], f.chars[i:i+n])
+			x += n
+		}
+	}
+	if pen != StateDefault {
+		s.buf.WriteString(s.caps().sgr0)
+	}
+
+	// Move cursor to correct position
+	s.moveTo(s.cursorX, s.cursorY)
+	s.write()
+}
@@ -0,0 +1,131 @@
+package termo
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// active holds the Screens between Init and Stop, so they can
+// be restored when the program panics or gets killed
+var active = struct {
+	sync.Mutex
+	screens map[*Screen]bool
+	signals chan os.Signal
+	handle  bool
+}{screens: map[*Screen]bool{}}
+
+// HandleSignals selects whether termo restores the terminal when the
+// program gets a signal that terminates it, like SIGTERM, SIGINT or
+// SIGHUP, before letting the signal go on. It's disabled by default.
+//
+// Once the terminal is restored, the signal is sent again. Channels
+// the program registered with signal.Notify get it then, and if there
+// are none, it kills the program as usual. Since Stop has been called
+// by then, programs with their own handlers for those signals should
+// only enable this if they exit when getting them. On Windows, where
+// signals can't be sent again, the program exits right away instead.
+//
+// SIGTSTP is not handled: once a program listens for it, the Go
+// runtime keeps catching it for good, so job control would stay
+// broken after Stop, like for programs that keep running after
+// drawing inline. Raw mode reports Ctrl+Z as a key, so programs
+// can call Suspend when they read it.
+func HandleSignals(enable bool) {
+	active.Lock()
+	defer active.Unlock()
+	active.handle = enable
+	if enable && len(active.screens) > 0 {
+		watchSignals()
+	} else {
+		unwatchSignals()
+	}
+}
+
+// register adds s to the active Screens
+func register(s *Screen) {
+	active.Lock()
+	defer active.Unlock()
+	active.screens[s] = true
+	if active.handle {
+		watchSignals()
+	}
+}
+
+// unregister removes s from the active Screens
+func unregister(s *Screen) {
+	active.Lock()
+	defer active.Unlock()
+	delete(active.screens, s)
+	if len(active.screens) == 0 {
+		unwatchSignals()
+	}
+}
+
+// watchSignals starts listening for terminating signals,
+// if it's not already doing it. active must be locked.
+func watchSignals() {
+	if active.signals != nil {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, terminatingSignals...)
+	active.signals = ch
+	go func() {
+		sig, ok := <-ch
+		if !ok {
+			return
+		}
+		stopAll()
+		active.Lock()
+		if active.signals == ch {
+			unwatchSignals()
+		}
+		active.Unlock()
+		reraise(sig)
+	}()
+}
+
+// unwatchSignals stops listening for terminating
+// signals. active must be locked.
+func unwatchSignals() {
+	if active.signals == nil {
+		return
+	}
+	signal.Stop(active.signals)
+	close(active.signals)
+	active.signals = nil
+}
+
+// stopAll restores the terminals of all active Screens
+func stopAll() {
+	active.Lock()
+	var screens []*Screen
+	for s := range active.screens {
+		screens = append(screens, s)
+	}
+	active.Unlock()
+	for _, s := range screens {
+		s.Stop()
+	}
+}
+
+// Guard runs f, and if it panics, restores the terminals of all
+// the Screens between Init and Stop before letting the panic go on,
+// so its message is readable. It's meant to wrap the body of main:
+//
+//	func main() {
+//		termo.Guard(run)
+//	}
+//
+// Panics in other goroutines can't be caught this way, so they
+// need their own Guard.
+func Guard(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			stopAll()
+			panic(r)
+		}
+	}()
+	f()
+}
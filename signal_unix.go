@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package termo
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminatingSignals are the signals that kill the
+// program unless it handles them
+var terminatingSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP}
+
+// reraise sends sig to the program again, once termo stopped
+// listening for it. It gets to the program's own handlers, if any,
+// and otherwise it has its default action, killing the program.
+func reraise(sig os.Signal) {
+	syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package termo
+
+import "os"
+
+// terminatingSignals are the signals that kill the
+// program unless it handles them
+var terminatingSignals = []os.Signal{os.Interrupt}
+
+// reraise exits the program, as there's no
+// way to send a signal again on Windows
+func reraise(sig os.Signal) {
+	os.Exit(2)
+}
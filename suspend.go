@@ -0,0 +1,105 @@
+package termo
+
+// Suspend puts the program in the background, like pressing Ctrl+Z
+// does for programs that don't use raw mode. The terminal is restored
+// first, leaving the alternate screen and turning off mouse reporting
+// and the like. When the program is brought back to the foreground,
+// all of that is enabled again, and a RedrawEvent is reported through
+// the input read loop. Terminals in raw mode report Ctrl+Z as a
+// KeyEvent, so programs wanting to support it should call Suspend
+// when they read it. Suspend is not supported on Windows.
+func (s *Screen) Suspend() error {
+	return suspendScreens([]*Screen{s})
+}
+
+// suspendScreens restores the terminals of all the Screens,
+// stops the program until it's continued, and then sets the
+// terminals up again. Paused Screens are left alone, as their
+// terminal is being used by someone else, like a child process.
+func suspendScreens(screens []*Screen) error {
+	if err := canSuspend(); err != nil {
+		return err
+	}
+	var resumes []func() error
+	for _, s := range screens {
+		s.mu.Lock()
+		paused := s.paused
+		s.mu.Unlock()
+		if !paused {
+			resumes = append(resumes, s.leave())
+		}
+	}
+	err := stopSelf()
+	for _, resume := range resumes {
+		if rerr := resume(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// leave restores the terminal to the state it had before Init, and
+// returns a function that sets it up again the way it was left. It
+// doesn't draw anything, since it can run on the signal handling
+// goroutine, so it asks for a redraw instead.
+func (s *Screen) leave() func() error {
+	pauseErr := s.Pause()
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+
+	mouse, paste, focus := s.mouse, s.paste, s.focus
+	alt, keypad, hidden := s.altScreen, s.keypad, s.cursorHidden
+	shape, color := s.cursorShape, s.cursorColor
+
+	s.buf.WriteString(s.caps().cnorm)
+	s.setCursorShape(CursorDefault)
+	s.setCursorColor(ColorDefault)
+	if keypad {
+		s.buf.WriteString(s.caps().rmkx)
+	}
+	s.setMouseMode(MouseOff)
+	s.setPrivateMode(2004, false, &s.paste)
+	s.setPrivateMode(1004, false, &s.focus)
+	s.setAltScreen(false)
+	if s.inline && s.inlineH > 0 {
+		// The shell prints its prompt below the drawing area
+		s.moveTo(0, s.inlineH-1)
+		s.buf.WriteString("\r\n")
+	}
+	s.write()
+
+	return func() error {
+		if pauseErr != nil {
+			return pauseErr
+		}
+		if err := s.Resume(); err != nil {
+			return err
+		}
+		s.outMu.Lock()
+		s.setAltScreen(alt)
+		if keypad {
+			s.buf.WriteString(s.caps().smkx)
+		}
+		if hidden {
+			s.buf.WriteString(s.caps().civis)
+		}
+		s.setCursorShape(shape)
+		s.setCursorColor(color)
+		s.setMouseMode(mouse)
+		s.setPrivateMode(2004, paste, &s.paste)
+		s.setPrivateMode(1004, focus, &s.focus)
+		// The inline drawing area is created again below the
+		// shell's output on the next flush
+		s.inlineH = 0
+		s.row = 0
+		s.front = nil
+		s.write()
+		s.outMu.Unlock()
+
+		s.mu.Lock()
+		s.redraw = true
+		s.interruptRead()
+		s.mu.Unlock()
+		return nil
+	}
+}
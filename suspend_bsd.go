@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package termo
+
+import "syscall"
+
+// getsid returns the session ID of the process pid
+func getsid(pid int) (int, error) {
+	return syscall.Getsid(pid)
+}
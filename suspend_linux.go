@@ -0,0 +1,12 @@
+package termo
+
+import "syscall"
+
+// getsid returns the session ID of the process pid
+func getsid(pid int) (int, error) {
+	sid, _, errno := syscall.RawSyscall(syscall.SYS_GETSID, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(sid), nil
+}
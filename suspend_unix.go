@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package termo
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// canSuspend returns an error if the program can't be suspended.
+// stopSelf uses SIGSTOP, so the check the kernel does for SIGTSTP,
+// which is ignored if the process group is orphaned, is done here.
+func canSuspend() error {
+	if orphaned() {
+		return errors.New("no shell left to continue the program")
+	}
+	return nil
+}
+
+// stopSelf stops the program, along with the rest of its process
+// group, and waits until it gets continued. SIGTSTP can't be used
+// for this, because if the program ever passed it to signal.Notify,
+// the runtime keeps catching it, even after signal.Reset.
+func stopSelf() error {
+	cont := make(chan os.Signal, 1)
+	signal.Notify(cont, syscall.SIGCONT)
+	defer signal.Stop(cont)
+	if err := syscall.Kill(0, syscall.SIGSTOP); err != nil {
+		return err
+	}
+	<-cont
+	return nil
+}
+
+// orphaned tells if the process group is orphaned, which is when none
+// of its processes has a parent in another group of the same session,
+// like the shell that started it. Nothing would continue a stopped
+// orphaned group. Only the parent of this process is checked, which
+// is the shell for most programs.
+func orphaned() bool {
+	ppid := os.Getppid()
+	ppgid, err := syscall.Getpgid(ppid)
+	if err != nil {
+		return true
+	}
+	if ppgid == syscall.Getpgrp() {
+		// The parent is in the group as well, so it's the one
+		// that has to have its parent in the session
+		return false
+	}
+	sid, err := getsid(0)
+	if err != nil {
+		return true
+	}
+	psid, err := getsid(ppid)
+	return err != nil || psid != sid
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package termo
+
+import "errors"
+
+// canSuspend fails on Windows, which has no job control
+func canSuspend() error {
+	return errors.New("suspend is not supported on Windows")
+}
+
+// stopSelf does nothing on Windows
+func stopSelf() error {
+	return nil
+}
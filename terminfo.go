@@ -0,0 +1,481 @@
+package termo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// terminfo holds the capabilities read from
+// a compiled terminfo database entry
+type terminfo struct {
+	names   []string
+	bools   []bool
+	numbers []int
+	strings []string
+
+	// ext holds the extended capabilities, which are identified
+	// by name. Booleans are stored as "1", and numbers in decimal.
+	ext map[string]string
+}
+
+// Indexes of the capabilities we use, as defined
+// by the order of the standard capabilities
+const (
+	tiColors = 13
+
+	tiClear = 5
+	tiEd    = 7
+	tiCup   = 10
+	tiCivis = 13
+	tiCnorm = 16
+	tiBlink = 26
+	tiBold  = 27
+	tiSmcup = 28
+	tiDim   = 30
+	tiInvis = 32
+	tiRev   = 34
+	tiSmul  = 36
+	tiSgr0  = 39
+	tiRmcup = 40
+	tiKbs   = 55
+	tiKdch1 = 59
+	tiKcud1 = 61
+	tiKf1   = 66
+	tiKf10  = 67
+	tiKf2   = 68
+	tiKhome = 76
+	tiKich1 = 77
+	tiKcub1 = 79
+	tiKnp   = 81
+	tiKpp   = 82
+	tiKcuf1 = 83
+	tiKcuu1 = 87
+	tiRmkx  = 88
+	tiSmkx  = 89
+	tiCud   = 107
+	tiCuf   = 112
+	tiCuu   = 114
+	tiKend  = 164
+	tiKf11  = 216
+	tiKf12  = 217
+	tiSitm  = 311
+	tiSetaf = 359
+	tiSetab = 360
+)
+
+// Magic numbers for the compiled terminfo formats
+const (
+	terminfoMagic   = 0432  // 16-bit numbers
+	terminfoMagic32 = 01036 // 32-bit numbers
+)
+
+var errBadTerminfo = errors.New("invalid terminfo entry")
+
+// terminfoDirs returns the directories to search for terminfo
+// entries, in the same order as ncurses does
+func terminfoDirs() []string {
+	var dirs []string
+	if d := os.Getenv("TERMINFO"); d != "" {
+		dirs = append(dirs, d)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	for _, d := range strings.Split(os.Getenv("TERMINFO_DIRS"), ":") {
+		if d == "" {
+			d = "/usr/share/terminfo"
+		}
+		dirs = append(dirs, d)
+	}
+	return append(dirs, "/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo")
+}
+
+// loadTerminfo finds and parses the terminfo entry for term
+func loadTerminfo(term string) (*terminfo, error) {
+	if term == "" || strings.ContainsAny(term, "/\\") {
+		return nil, os.ErrNotExist
+	}
+	for _, dir := range terminfoDirs() {
+		// Entries are in a subdirectory named after their first
+		// letter, or its hex code in some systems (like macOS)
+		for _, sub := range []string{term[:1], strings.ToLower(hexByte(term[0]))} {
+			data, err := ioutil.ReadFile(filepath.Join(dir, sub, term))
+			if err == nil {
+				return parseTerminfo(data)
+			}
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func hexByte(b byte) string {
+	const digits = "0123456789ABCDEF"
+	return string([]byte{digits[b>>4], digits[b&0xf]})
+}
+
+// parseTerminfo decodes a compiled terminfo entry, as described in term(5)
+func parseTerminfo(data []byte) (*terminfo, error) {
+	le := binary.LittleEndian
+	if len(data) < 12 {
+		return nil, errBadTerminfo
+	}
+	var header [6]int
+	for i := range header {
+		header[i] = int(int16(le.Uint16(data[i*2:])))
+	}
+	numSize := 2
+	switch header[0] {
+	case terminfoMagic:
+	case terminfoMagic32:
+		numSize = 4
+	default:
+		return nil, errBadTerminfo
+	}
+	namesSize, boolCount, numCount, strCount, tableSize := header[1], header[2], header[3], header[4], header[5]
+	for _, n := range header[1:] {
+		if n < 0 {
+			return nil, errBadTerminfo
+		}
+	}
+
+	ti := &terminfo{}
+	pos := 12
+	if pos+namesSize+boolCount > len(data) {
+		return nil, errBadTerminfo
+	}
+	names := strings.TrimRight(string(data[pos:pos+namesSize]), "\x00")
+	ti.names = strings.Split(names, "|")
+	pos += namesSize
+
+	ti.bools = make([]bool, boolCount)
+	for i := range ti.bools {
+		ti.bools[i] = data[pos+i] == 1
+	}
+	pos += boolCount
+	if pos%2 == 1 {
+		pos++
+	}
+
+	if pos+numCount*numSize+strCount*2+tableSize > len(data) {
+		return nil, errBadTerminfo
+	}
+	ti.numbers = readNumbers(data[pos:], numCount, numSize)
+	pos += numCount * numSize
+
+	table := data[pos+strCount*2 : pos+strCount*2+tableSize]
+	ti.strings, _ = readStrings(data[pos:], strCount, table)
+	pos += strCount*2 + tableSize
+	if pos%2 == 1 {
+		pos++
+	}
+
+	// ncurses adds the extended capabilities after the standard
+	// ones. Failing to read them is not fatal.
+	ti.ext, _ = parseExtended(data[pos:], numSize)
+	return ti, nil
+}
+
+// parseExtended decodes the extended capabilities section of a
+// terminfo entry, which has the same layout as the standard
+// one, plus the names of all the capabilities after the strings
+func parseExtended(data []byte, numSize int) (map[string]string, error) {
+	le := binary.LittleEndian
+	if len(data) < 10 {
+		return nil, errBadTerminfo
+	}
+	var header [5]int
+	for i := range header {
+		header[i] = int(int16(le.Uint16(data[i*2:])))
+		if header[i] < 0 {
+			return nil, errBadTerminfo
+		}
+	}
+	boolCount, numCount, strCount, tableSize := header[0], header[1], header[2], header[4]
+	nameCount := boolCount + numCount + strCount
+
+	pos := 10
+	if pos+boolCount+boolCount%2+numCount*numSize+(strCount+nameCount)*2+tableSize > len(data) {
+		return nil, errBadTerminfo
+	}
+	bools := data[pos : pos+boolCount]
+	pos += boolCount
+	if pos%2 == 1 {
+		pos++
+	}
+	numbers := readNumbers(data[pos:], numCount, numSize)
+	pos += numCount * numSize
+
+	table := data[pos+(strCount+nameCount)*2 : pos+(strCount+nameCount)*2+tableSize]
+	values, end := readStrings(data[pos:], strCount, table)
+	names, _ := readStrings(data[pos+strCount*2:], nameCount, table[end:])
+	if len(names) != nameCount {
+		return nil, errBadTerminfo
+	}
+
+	ext := map[string]string{}
+	for i, b := range bools {
+		if b == 1 {
+			ext[names[i]] = "1"
+		}
+	}
+	for i, n := range numbers {
+		if n >= 0 {
+			ext[names[boolCount+i]] = strconv.Itoa(n)
+		}
+	}
+	for i, v := range values {
+		if v != "" {
+			ext[names[boolCount+numCount+i]] = v
+		}
+	}
+	return ext, nil
+}
+
+// readNumbers decodes count numbers of the given size
+func readNumbers(data []byte, count, size int) []int {
+	le := binary.LittleEndian
+	numbers := make([]int, count)
+	for i := range numbers {
+		if size == 4 {
+			numbers[i] = int(int32(le.Uint32(data[i*4:])))
+		} else {
+			numbers[i] = int(int16(le.Uint16(data[i*2:])))
+		}
+	}
+	return numbers
+}
+
+// readStrings decodes count strings, given their offsets into a
+// table of NUL-terminated strings. Missing strings are left empty.
+// It also returns where the last string in the table ends.
+func readStrings(offsets []byte, count int, table []byte) ([]string, int) {
+	strs := make([]string, count)
+	end := 0
+	for i := range strs {
+		off := int(int16(binary.LittleEndian.Uint16(offsets[i*2:])))
+		if off < 0 || off >= len(table) {
+			continue
+		}
+		n := bytes.IndexByte(table[off:], 0)
+		if n < 0 {
+			n = len(table) - off
+		}
+		strs[i] = string(table[off : off+n])
+		if off+n+1 > end {
+			end = off + n + 1
+		}
+	}
+	if end > len(table) {
+		end = len(table)
+	}
+	return strs, end
+}
+
+// number returns the numeric capability with the given
+// index, or -1 if the terminal doesn't have it
+func (ti *terminfo) number(i int) int {
+	if i >= len(ti.numbers) || ti.numbers[i] < 0 {
+		return -1
+	}
+	return ti.numbers[i]
+}
+
+// str returns the string capability with the given index,
+// or an empty string if the terminal doesn't have it
+func (ti *terminfo) str(i int) string {
+	if i >= len(ti.strings) {
+		return ""
+	}
+	return ti.strings[i]
+}
+
+// flag tells if the terminal has the extended
+// boolean capability with the given name
+func (ti *terminfo) flag(name string) bool {
+	return ti.ext[name] == "1"
+}
+
+// tparm expands the parameters in a string capability, as
+// described in terminfo(5). Padding specifications are dropped.
+func tparm(s string, params ...int) string {
+	var p [9]int
+	copy(p[:], params)
+	var (
+		out   strings.Builder
+		stack []int
+		vars  [52]int
+	)
+	push := func(v int) { stack = append(stack, v) }
+	pop := func() int {
+		if len(stack) == 0 {
+			return 0
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	bool2int := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	// skip moves i past the current branch of a conditional, up to
+	// the matching %e (if else is set) or %;
+	skip := func(i int, toElse bool) int {
+		depth := 0
+		for ; i < len(s)-1; i++ {
+			if s[i] != '%' {
+				continue
+			}
+			i++
+			switch s[i] {
+			case '?':
+				depth++
+			case ';':
+				if depth == 0 {
+					return i + 1
+				}
+				depth--
+			case 'e':
+				if depth == 0 && toElse {
+					return i + 1
+				}
+			}
+		}
+		return len(s)
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '$' && i+1 < len(s) && s[i+1] == '<' {
+			if end := strings.IndexByte(s[i:], '>'); end >= 0 {
+				i += end
+				continue
+			}
+		}
+		if c != '%' || i+1 == len(s) {
+			out.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = s[i]; c {
+		case '%':
+			out.WriteByte('%')
+		case 'c':
+			out.WriteByte(byte(pop()))
+		case 's':
+			out.WriteString(strconv.Itoa(pop()))
+		case 'p':
+			if i+1 < len(s) && s[i+1] >= '1' && s[i+1] <= '9' {
+				i++
+				push(p[s[i]-'1'])
+			}
+		case 'P', 'g':
+			if i+1 == len(s) {
+				break
+			}
+			i++
+			v := s[i]
+			var n int
+			switch {
+			case v >= 'a' && v <= 'z':
+				n = int(v - 'a')
+			case v >= 'A' && v <= 'Z':
+				n = int(v-'A') + 26
+			default:
+				continue
+			}
+			if c == 'P' {
+				vars[n] = pop()
+			} else {
+				push(vars[n])
+			}
+		case '\'':
+			if i+2 < len(s) {
+				push(int(s[i+1]))
+				i += 2
+			}
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				break
+			}
+			n, _ := strconv.Atoi(s[i+1 : i+end])
+			push(n)
+			i += end
+		case 'l':
+			push(len(strconv.Itoa(pop())))
+		case 'i':
+			p[0]++
+			p[1]++
+		case '+', '-', '*', '/', 'm', '&', '|', '^', '=', '>', '<', 'A', 'O':
+			b, a := pop(), pop()
+			switch c {
+			case '+':
+				push(a + b)
+			case '-':
+				push(a - b)
+			case '*':
+				push(a * b)
+			case '/', 'm':
+				if b == 0 {
+					push(0)
+				} else if c == '/' {
+					push(a / b)
+				} else {
+					push(a % b)
+				}
+			case '&':
+				push(a & b)
+			case '|':
+				push(a | b)
+			case '^':
+				push(a ^ b)
+			case '=':
+				push(bool2int(a == b))
+			case '>':
+				push(bool2int(a > b))
+			case '<':
+				push(bool2int(a < b))
+			case 'A':
+				push(bool2int(a != 0 && b != 0))
+			case 'O':
+				push(bool2int(a != 0 || b != 0))
+			}
+		case '!':
+			push(bool2int(pop() == 0))
+		case '~':
+			push(^pop())
+		case '?', ';':
+		case 't':
+			if pop() == 0 {
+				i = skip(i+1, true) - 1
+			}
+		case 'e':
+			// Reached the end of a branch that was taken
+			i = skip(i+1, false) - 1
+		default:
+			// Formatted output, like %d or %02x
+			end := strings.IndexAny(s[i:], "doxXs")
+			if end < 0 {
+				break
+			}
+			format := "%" + strings.TrimPrefix(s[i:i+end+1], ":")
+			i += end
+			if s[i] == 's' {
+				fmt.Fprintf(&out, format, strconv.Itoa(pop()))
+			} else {
+				fmt.Fprintf(&out, format, pop())
+			}
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,226 @@
+package termo
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var tparmTests = []struct {
+	cap    string
+	params []int
+	out    string
+}{
+	{"\033[%i%p1%d;%p2%dH", []int{9, 4}, "\033[10;5H"},
+	{"\033[%?%p1%{8}%<%t3%p1%d%e%p1%{16}%<%t9%p1%{8}%-%d%e38;5;%p1%d%;m", []int{3}, "\033[33m"},
+	{"\033[%?%p1%{8}%<%t3%p1%d%e%p1%{16}%<%t9%p1%{8}%-%d%e38;5;%p1%d%;m", []int{12}, "\033[94m"},
+	{"\033[%?%p1%{8}%<%t3%p1%d%e%p1%{16}%<%t9%p1%{8}%-%d%e38;5;%p1%d%;m", []int{200}, "\033[38;5;200m"},
+	{"\033=%p1%' '%+%c%p2%' '%+%c", []int{1, 2}, "\033=!\""},
+	{"\033[%p1%03d;%p1%x$<5>", []int{42}, "\033[042;2a"},
+	{"%p1%Pa%ga%ga%*%d %%", []int{7}, "49 %"},
+	{"%?%p1%p2%>%tbig%esmall%;", []int{2, 1}, "big"},
+}
+
+func TestTparm(t *testing.T) {
+	for i, test := range tparmTests {
+		if out := tparm(test.cap, test.params...); out != test.out {
+			t.Errorf("tparm test %d returned %q, expected %q", i, out, test.out)
+		}
+	}
+}
+
+func TestTerminfoKeys(t *testing.T) {
+	p := inputParser{keys: []termKey{
+		{"\033[11~", KeyF1},
+		{"\033[8~", KeyEnd},
+		{"\010", KeyBackspace},
+	}}
+	events := p.feed([]byte("\033[11~\033[8~\010\033[A"))
+	expected := []Event{
+		KeyEvent{Key: KeyF1},
+		KeyEvent{Key: KeyEnd},
+		KeyEvent{Key: KeyBackspace},
+		KeyEvent{Key: KeyUp},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Events from terminfo keys were %#v, expected %#v", events, expected)
+	}
+}
+
+func TestNonANSIStateDiff(t *testing.T) {
+	c := &caps{sgr0: "\033[m", bold: "\033[1m", rev: "\033[7m", setaf: "\033[38;5;%p1%dm"}
+	diff := c.stateDiff(StateDefault, CellState{Attrib: AttrBold | AttrRev, FGColor: ColorRed})
+	if want := "\033[m\033[1m\033[7m\033[38;5;1m"; diff != want {
+		t.Fatalf("state diff was %q, expected %q", diff, want)
+	}
+}
+
+func TestSetTermColorMode(t *testing.T) {
+	s := NewScreen(&bytes.Buffer{}, &bytes.Buffer{})
+	for _, test := range []struct {
+		term string
+		mode ColorMode
+	}{
+		{"xterm-256color", ColorMode256},
+		{"xterm-direct", ColorModeTrueColor},
+		{"dumb", ColorModeNone},
+	} {
+		s.SetTerm(test.term)
+		if m := s.ColorMode(); m != test.mode {
+			t.Fatalf("Color mode for %s was %v, expected %v", test.term, m, test.mode)
+		}
+	}
+
+	// Set modes are kept
+	s.SetColorMode(ColorMode16)
+	s.SetTerm("xterm-direct")
+	if m := s.ColorMode(); m != ColorMode16 {
+		t.Fatalf("Color mode after SetColorMode was %v, expected %v", m, ColorMode16)
+	}
+}
+
+// Compiled terminfo entries, made with tic -x from
+//
+//	t16|termo test,
+//		am, colors#8, cols#80,
+//		bold=\E[1m, cup=\E[%i%p1%d;%p2%dH,
+//		RGB, U8#1, Ss=\E[%p1%d q,
+//
+// and from the same source as t32, with colors#16777216,
+// which needs the format with 32-bit numbers
+const (
+	terminfo16 = "" +
+		"\x1a\x01\x0f\x00\x02\x00\x0e\x00\x1c\x00\x16\x00\x74\x31\x36\x7c" +
+		"\x74\x65\x72\x6d\x6f\x20\x74\x65\x73\x74\x00\x00\x01\x00\x50\x00" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\x08\x00\xff\xff\xff\xff\xff\xff" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x00\x00" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\x11\x00\x1b\x5b\x25\x69\x25\x70\x31\x25\x64\x3b\x25\x70\x32\x25" +
+		"\x64\x48\x00\x1b\x5b\x31\x6d\x00\x01\x00\x01\x00\x01\x00\x04\x00" +
+		"\x14\x00\x01\x00\x01\x00\x00\x00\x00\x00\x04\x00\x07\x00\x1b\x5b" +
+		"\x25\x70\x31\x25\x64\x20\x71\x00\x52\x47\x42\x00\x55\x38\x00\x53" +
+		"\x73\x00"
+	terminfo32 = "" +
+		"\x1e\x02\x0f\x00\x02\x00\x0e\x00\x1c\x00\x16\x00\x74\x33\x32\x7c" +
+		"\x74\x65\x72\x6d\x6f\x20\x74\x65\x73\x74\x00\x00\x01\x00\x50\x00" +
+		"\x00\x00\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\xff\xff\x00\x00\x00\x01\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x00\x00\xff\xff\xff\xff" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x11\x00\x1b\x5b" +
+		"\x25\x69\x25\x70\x31\x25\x64\x3b\x25\x70\x32\x25\x64\x48\x00\x1b" +
+		"\x5b\x31\x6d\x00\x01\x00\x01\x00\x01\x00\x04\x00\x14\x00\x01\x00" +
+		"\x01\x00\x00\x00\x00\x00\x00\x00\x04\x00\x07\x00\x1b\x5b\x25\x70" +
+		"\x31\x25\x64\x20\x71\x00\x52\x47\x42\x00\x55\x38\x00\x53\x73\x00"
+)
+
+func TestParseTerminfo(t *testing.T) {
+	for _, test := range []struct {
+		data   string
+		name   string
+		colors int
+	}{
+		{terminfo16, "t16", 8},
+		{terminfo32, "t32", 16777216},
+	} {
+		ti, err := parseTerminfo([]byte(test.data))
+		if err != nil {
+			t.Fatalf("Parsing %s failed with %v", test.name, err)
+		}
+		if want := []string{test.name, "termo test"}; !reflect.DeepEqual(ti.names, want) {
+			t.Errorf("Names of %s were %q, expected %q", test.name, ti.names, want)
+		}
+		if len(ti.bools) < 2 || !ti.bools[1] {
+			t.Errorf("%s is missing the am flag", test.name)
+		}
+		if n := ti.number(0); n != 80 {
+			t.Errorf("cols of %s was %d, expected 80", test.name, n)
+		}
+		if n := ti.number(tiColors); n != test.colors {
+			t.Errorf("colors of %s was %d, expected %d", test.name, n, test.colors)
+		}
+		if s := ti.str(tiBold); s != "\033[1m" {
+			t.Errorf("bold of %s was %q", test.name, s)
+		}
+		if s := ti.str(tiCup); s != "\033[%i%p1%d;%p2%dH" {
+			t.Errorf("cup of %s was %q", test.name, s)
+		}
+		if s := ti.str(tiSmul); s != "" {
+			t.Errorf("Missing smul of %s was %q", test.name, s)
+		}
+		want := map[string]string{"RGB": "1", "U8": "1", "Ss": "\033[%p1%d q"}
+		if !reflect.DeepEqual(ti.ext, want) {
+			t.Errorf("Extended capabilities of %s were %q, expected %q", test.name, ti.ext, want)
+		}
+	}
+}
+
+func TestParseTruncatedTerminfo(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		// end of the standard capabilities,
+		// where the extended ones start
+		end int
+	}{
+		{terminfo16, 136},
+		{terminfo32, 164},
+	} {
+		for n := 0; n < len(test.data); n++ {
+			ti, err := parseTerminfo([]byte(test.data[:n]))
+			switch {
+			case n < test.end && err == nil:
+				t.Errorf("Parsing the first %d bytes didn't fail", n)
+			case n >= test.end && err != nil:
+				t.Errorf("Parsing the first %d bytes failed with %v", n, err)
+			case n >= test.end && ti.ext != nil:
+				t.Errorf("Got %q from truncated extended capabilities", ti.ext)
+			}
+		}
+	}
+}
+
+func TestParseCorruptTerminfo(t *testing.T) {
+	patch := func(off int, b ...byte) []byte {
+		data := []byte(terminfo16)
+		copy(data[off:], b)
+		return data
+	}
+	for i, data := range [][]byte{
+		patch(0, 0x1a, 0x02),  // bad magic
+		patch(2, 0xff, 0xff),  // negative names size
+		patch(6, 0xff, 0x7f),  // too many numbers
+		patch(8, 0xff, 0x7f),  // too many strings
+		patch(10, 0x00, 0x7f), // string table too big
+	} {
+		if _, err := parseTerminfo(data); err == nil {
+			t.Errorf("Parsing corrupt entry %d didn't fail", i)
+		}
+	}
+
+	// Bad string offsets leave the capability empty
+	ti, err := parseTerminfo(patch(58+tiBold*2, 0x00, 0x10))
+	if err != nil {
+		t.Fatalf("Parsing entry with a bad bold offset failed with %v", err)
+	}
+	if ti.str(tiBold) != "" || ti.str(tiCup) == "" {
+		t.Errorf("Bad bold offset gave bold %q, cup %q", ti.str(tiBold), ti.str(tiCup))
+	}
+
+	// and bad extended capabilities are skipped
+	for i, data := range [][]byte{
+		patch(136, 0xff, 0x7f), // too many flags
+		patch(140, 0xff, 0x7f), // too many strings
+		patch(144, 0xff, 0xff), // negative table size
+	} {
+		ti, err := parseTerminfo(data)
+		if err != nil {
+			t.Errorf("Parsing corrupt extended capabilities %d failed with %v", i, err)
+		} else if ti.str(tiBold) != "\033[1m" || ti.ext != nil {
+			t.Errorf("Corrupt extended capabilities %d gave bold %q, %q", i, ti.str(tiBold), ti.ext)
+		}
+	}
+}
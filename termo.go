@@ -1,73 +1,188 @@
 package termo
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
-	"syscall"
+	"time"
 	"unicode/utf8"
-
-	"github.com/jonvaldes/termo/terminal"
 )
 
 // ErrNotATerminal is the error returned when running
 // termo in an unsupported environment
 var ErrNotATerminal = errors.New("not running in a terminal")
 
-var oldTermState *terminal.State
+// ErrStopped is the error returned when reading
+// events from a Screen after calling Stop
+var ErrStopped = errors.New("screen stopped")
+
+// defaultScreen is the Screen used by the package-level
+// functions, working on the process' stdin and stdout
+var defaultScreen = NewScreen(os.Stdin, os.Stdout)
 
-// Control sequences documentation: http://www.xfree86.org/current/ctlseqs.html
+// SetOutput makes the package-level functions write
+// to w instead of os.Stdout
+func SetOutput(w io.Writer) {
+	defaultScreen.outMu.Lock()
+	defer defaultScreen.outMu.Unlock()
+	defaultScreen.out = w
+}
 
 // Init initializes termo to work with the terminal
 func Init() error {
-	if !terminal.IsTerminal(syscall.Stdin) {
-		return ErrNotATerminal
-	}
-	var err error
-	oldTermState, err = terminal.MakeRaw(syscall.Stdin)
-	if err != nil {
-		panic(err)
-	}
-	HideCursor()
-	return nil
+	return defaultScreen.Init()
+}
+
+// InitInline initializes termo to draw in an area starting at
+// the cursor row, instead of using the whole screen. If keep is
+// set, the last frame drawn is left behind after Stop.
+func InitInline(keep bool) error {
+	return defaultScreen.InitInline(keep)
 }
 
 // Stop restores the terminal to its original state
 func Stop() {
-	terminal.Restore(syscall.Stdin, oldTermState)
-	ShowCursor()
-	fmt.Printf("\033[?1003l") // Reset mouse
+	defaultScreen.Stop()
+}
+
+// Pause stops reading input and restores the terminal
+// to its original mode, so it can be used by something
+// else, like a child process
+func Pause() error {
+	return defaultScreen.Pause()
+}
+
+// Resume puts the terminal back in raw mode
+// after Pause, and makes input reading continue
+func Resume() error {
+	return defaultScreen.Resume()
+}
+
+// Suspend restores the terminal and puts the program in the
+// background, setting everything up again when it's brought
+// back to the foreground. Call it when reading Ctrl+Z to
+// get the usual behaviour of non-fullscreen programs.
+func Suspend() error {
+	return defaultScreen.Suspend()
 }
 
 // HideCursor makes the cursor invisible
 func HideCursor() {
-	fmt.Printf("\033[?25l")
+	defaultScreen.HideCursor()
 }
 
 // ShowCursor makes the cursor visible
 func ShowCursor() {
-	fmt.Printf("\033[?25h")
+	defaultScreen.ShowCursor()
 }
 
-var cursorPos [2]int
-
 // SetCursor positions the cursor at the specified coordinates.
 // Cursor visibility is not affected.
 func SetCursor(x, y int) {
-	cursorPos[0] = x
-	cursorPos[1] = y
-	fmt.Printf("\033[%d;%dH", y+1, x+1)
+	defaultScreen.SetCursor(x, y)
 }
 
-// EnableMouseEvents makes mouse events start
-// arriving through the input read loop
+// SetCursorShape changes how the cursor looks, until Stop
+func SetCursorShape(shape CursorShape) {
+	defaultScreen.SetCursorShape(shape)
+}
+
+// SetCursorColor changes the color of the cursor, until Stop
+func SetCursorColor(c Color) {
+	defaultScreen.SetCursorColor(c)
+}
+
+// SetMouseMode selects which mouse events
+// arrive through the input read loop
+func SetMouseMode(m MouseMode) {
+	defaultScreen.SetMouseMode(m)
+}
+
+// EnableMouseEvents makes all mouse events, including
+// motion, start arriving through the input read loop
 func EnableMouseEvents() {
-	fmt.Printf("\033[?1003h")
+	defaultScreen.EnableMouseEvents()
+}
+
+// DisableMouseEvents stops mouse events from
+// arriving through the input read loop
+func DisableMouseEvents() {
+	defaultScreen.DisableMouseEvents()
+}
+
+// EnableBracketedPaste makes pasted text arrive through the input
+// read loop as a single PasteEvent, instead of as individual keys
+func EnableBracketedPaste() {
+	defaultScreen.EnableBracketedPaste()
+}
+
+// DisableBracketedPaste makes pasted text
+// arrive as individual keys again
+func DisableBracketedPaste() {
+	defaultScreen.DisableBracketedPaste()
+}
+
+// EnableFocusEvents makes the terminal report a FocusEvent through
+// the input read loop each time its window gains or loses the focus
+func EnableFocusEvents() {
+	defaultScreen.EnableFocusEvents()
+}
+
+// DisableFocusEvents stops focus events from
+// arriving through the input read loop
+func DisableFocusEvents() {
+	defaultScreen.DisableFocusEvents()
+}
+
+// EnableKittyKeyboard asks the terminal to use the kitty keyboard
+// protocol with the given enhancements, if it supports it
+func EnableKittyKeyboard(flags KittyFlags) {
+	defaultScreen.EnableKittyKeyboard(flags)
+}
+
+// KittyKeyboardActive returns true if the terminal
+// is using the kitty keyboard protocol
+func KittyKeyboardActive() bool {
+	return defaultScreen.KittyKeyboardActive()
+}
+
+// EnableAltScreen switches to the terminal's alternate screen,
+// leaving the shell contents untouched until Stop
+func EnableAltScreen() {
+	defaultScreen.EnableAltScreen()
+}
+
+// DisableAltScreen switches back to the main screen
+func DisableAltScreen() {
+	defaultScreen.DisableAltScreen()
+}
+
+// SetColorMode overrides the colors the terminal is assumed
+// to support, which are otherwise detected from the environment
+func SetColorMode(m ColorMode) {
+	defaultScreen.SetColorMode(m)
+}
+
+// SetEscapeDelay sets how long ReadEvent waits after reading a lone
+// ESC byte for the rest of an escape sequence, before deciding it's
+// the escape key being pressed. Slow links might need longer delays.
+func SetEscapeDelay(d time.Duration) {
+	defaultScreen.SetEscapeDelay(d)
 }
 
 // Size returns the current size of the terminal
 func Size() (int, int, error) {
-	return terminal.GetSize(syscall.Stdin)
+	return defaultScreen.Size()
+}
+
+// ForceFullRedraw makes the next Flush repaint every cell, instead
+// of only the ones that changed. Useful when the terminal contents
+// got corrupted by some external program.
+func ForceFullRedraw() {
+	defaultScreen.ForceFullRedraw()
 }
 
 // ScanCode contains data for a terminal keypress
@@ -102,7 +217,7 @@ func (s ScanCode) IsMouseUpEvent() bool {
 // MouseCoords returns data for the mouse position.
 // Returned coords start at [0,0] for upper-left corner
 func (s ScanCode) MouseCoords() (int, int) {
-	return int(s[4] - 33), int(s[5] - 33)
+	return int(s[4]) - 33, int(s[5]) - 33
 }
 
 // Rune returns the actual key pressed (only for
@@ -114,75 +229,110 @@ func (s ScanCode) Rune() rune {
 
 // ReadScanCode reads a keypress from stdin.
 // It will block until it can read something
+//
+// Deprecated: use ReadEvent, which handles multi-byte
+// sequences and several keys arriving at once
 func ReadScanCode() (ScanCode, error) {
-	s := ScanCode{0, 0, 0, 0, 0, 0}
-	_, err := syscall.Read(syscall.Stdin, s)
-	return s, err
+	return defaultScreen.ReadScanCode()
 }
 
 // StartKeyReadLoop runs a goroutine that
 // keeps reading terminal input forever.
 // It returns events through the keyChan param, and
 // errors through the errChan parameter
+//
+// Deprecated: use StartEventReadLoop
 func StartKeyReadLoop(keyChan chan<- ScanCode, errChan chan<- error) {
-	go func() {
-		for {
-			s, err := ReadScanCode()
-			if err != nil {
-				errChan <- err
-				return
-			}
-			keyChan <- s
-		}
-	}()
+	defaultScreen.StartKeyReadLoop(keyChan, errChan)
 }
 
-// Attribute holds data for each
-// possible visualization mode
-type Attribute int
+// ReadEvent reads the next event from stdin.
+// It will block until a complete event is available.
+func ReadEvent() (Event, error) {
+	return defaultScreen.ReadEvent()
+}
 
-// Attributes for different character
-// visualization modes
-const (
-	AttrNone  Attribute = 0
-	AttrBold  Attribute = 1
-	AttrDim   Attribute = 2
-	AttrUnder Attribute = 4
-	AttrBlink Attribute = 5
-	AttrRev   Attribute = 7
-	AttrHid   Attribute = 8
-)
+// ReadEventContext is like ReadEvent, but returns
+// ctx.Err() as soon as ctx gets cancelled
+func ReadEventContext(ctx context.Context) (Event, error) {
+	return defaultScreen.ReadEventContext(ctx)
+}
+
+// RunEventLoop keeps reading terminal events and sending
+// them through eventChan, until ctx gets cancelled or
+// reading fails
+func RunEventLoop(ctx context.Context, eventChan chan<- Event) error {
+	return defaultScreen.RunEventLoop(ctx, eventChan)
+}
+
+// StartEventReadLoop runs a goroutine that
+// keeps reading terminal events until Stop.
+// It returns events through the eventChan param, and
+// errors through the errChan parameter
+func StartEventReadLoop(eventChan chan<- Event, errChan chan<- error) {
+	defaultScreen.StartEventReadLoop(eventChan, errChan)
+}
 
-// Color holds character color information
-type Color int
+// Attribute holds data for each possible visualization
+// mode. Attributes can be combined, like AttrBold|AttrUnder.
+type Attribute uint16
 
-// Different colors to use as attributes
+// Attributes for different character
+// visualization modes
 const (
-	ColorBlack Color = 30 + iota
-	ColorRed
-	ColorGreen
-	ColorYellow
-	ColorBlue
-	ColorMagenta
-	ColorCyan
-	ColorGray
-	ColorDefault Color = 39
+	AttrNone Attribute = 0
+	AttrBold Attribute = 1 << (iota - 1)
+	AttrDim
+	AttrUnder
+	AttrBlink
+	AttrRev
+	AttrHid
+	AttrItalic
+	AttrStrike
+	AttrOverline
+	// Underline styles. If more than one is set, curly
+	// takes precedence over double, and double over AttrUnder
+	AttrDoubleUnder
+	AttrCurlyUnder
 )
 
-// Light returns the "ligther" version for that color
-func (c Color) Light() Color {
-	return c + 60
-}
-
-func background(c Color) Color {
-	return c + 10
+// attribCodes holds the SGR codes that turn each attribute
+// on and off, except for underlines, which are handled apart
+var attribCodes = []struct {
+	attr    Attribute
+	on, off int
+}{
+	{AttrBold, 1, 22},
+	{AttrDim, 2, 22},
+	{AttrItalic, 3, 23},
+	{AttrBlink, 5, 25},
+	{AttrRev, 7, 27},
+	{AttrHid, 8, 28},
+	{AttrStrike, 9, 29},
+	{AttrOverline, 53, 55},
+}
+
+// underlineSGR returns the SGR code for the underline style
+// in a set of attributes, or "24" if there's no underline
+func underlineSGR(a Attribute) string {
+	switch {
+	case a&AttrCurlyUnder != 0:
+		return "4:3"
+	case a&AttrDoubleUnder != 0:
+		return "21"
+	case a&AttrUnder != 0:
+		return "4"
+	}
+	return "24"
 }
 
-// CellState holds all the attributes for a cell
+// CellState holds all the attributes for a cell. ULColor is
+// the color for underlines, which not all terminals support.
 type CellState struct {
 	Attrib  Attribute
 	FGColor Color
 	BGColor Color
+	ULColor Color
 }
 
 // Predefined attributes
@@ -192,61 +342,169 @@ var (
 	BoldBlackOnWhite = CellState{Attrib: AttrBold, FGColor: ColorBlack, BGColor: ColorGray.Light()}
 )
 
+// sgrDiff returns the SGR sequence needed to change the terminal pen
+// from one CellState to another, only including the parameters that
+// differ. It returns an empty string if both states are the same.
+func sgrDiff(from, to CellState) string {
+	var params []string
+	if from.Attrib != to.Attrib {
+		off := from.Attrib &^ to.Attrib
+		on := to.Attrib &^ from.Attrib
+		if off&(AttrBold|AttrDim) != 0 {
+			// Bold and dim are turned off by the same
+			// code, so the other one might need to be
+			// turned on again
+			params = append(params, "22")
+			on |= to.Attrib & (AttrBold | AttrDim)
+		}
+		for _, c := range attribCodes {
+			if off&c.attr != 0 && c.off != 22 {
+				params = append(params, strconv.Itoa(c.off))
+			}
+		}
+		for _, c := range attribCodes {
+			if on&c.attr != 0 {
+				params = append(params, strconv.Itoa(c.on))
+			}
+		}
+		if u := underlineSGR(to.Attrib); u != underlineSGR(from.Attrib) {
+			params = append(params, u)
+		}
+	}
+	if from.FGColor != to.FGColor {
+		params = append(params, to.FGColor.sgr(sgrForeground))
+	}
+	if from.BGColor != to.BGColor {
+		params = append(params, to.BGColor.sgr(sgrBackground))
+	}
+	if from.ULColor != to.ULColor {
+		params = append(params, to.ULColor.sgr(sgrUnderline))
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return "\033[" + strings.Join(params, ";") + "m"
+}
+
 type cell struct {
 	state CellState
 	r     rune
+	// comb holds the runes that attach to r, like
+	// combining accents or emoji modifiers
+	comb string
+	// cont marks the second half of a wide character
+	cont bool
 }
 
 // Framebuffer contains the runes and attributes
 // that will be drawn in the terminal
 type Framebuffer struct {
-	w, h  int
-	chars []cell
+	w, h   int
+	chars  []cell
+	screen *Screen
 }
 
 // NewFramebuffer creates a Framebuffer with the specified size
 // and initializes it filling it with blank spaces and default
 // attributes
 func NewFramebuffer(w, h int) *Framebuffer {
-	result := &Framebuffer{w, h, make([]cell, w*h)}
-	result.Clear()
-	return result
+	return defaultScreen.NewFramebuffer(w, h)
 }
 
 // Get returns the rune stored in the [x,y] position.
-// If coords are outside the framebuffer size, it returns ' '
+// If coords are outside the framebuffer size, it returns ' '.
+// The second half of a wide character reads as 0.
 func (f *Framebuffer) Get(x, y int) (rune, CellState) {
 	if x < 0 || y < 0 || x >= f.w || y >= f.h {
-		return ' ', CellState{AttrNone, ColorDefault, ColorDefault}
+		return ' ', StateDefault
 	}
 	c := f.chars[x+y*f.w]
 	return c.r, c.state
 }
 
-// Set sets a rune in the specified position with the specified attributes
+// Set sets a rune in the specified position with the specified attributes.
+// Wide characters also take the cell to their right.
 func (f *Framebuffer) Set(x, y int, s CellState, r rune) {
-	if x < 0 || y < 0 || x >= f.w || y >= f.h {
-		return
-	}
-	f.chars[x+y*f.w].r = r
-	f.chars[x+y*f.w].state = s
+	f.put(x, y, &s, string(r), cellWidth(r))
 }
 
 // SetRune sets a rune in the specified position without modifying its attributes
 func (f *Framebuffer) SetRune(x, y int, r rune) {
-	if x < 0 || y < 0 || x >= f.w || y >= f.h {
-		return
-	}
-	f.chars[x+y*f.w].r = r
+	f.put(x, y, nil, string(r), cellWidth(r))
 }
 
 // SetRect fills a rectangular region with a rune and state
 func (f *Framebuffer) SetRect(x0, y0, w, h int, s CellState, r rune) {
+	cw := cellWidth(r)
 	for y := y0; y < y0+h; y++ {
-		for x := x0; x < x0+w; x++ {
+		x := x0
+		for ; x+cw <= x0+w; x += cw {
 			f.Set(x, y, s, r)
 		}
+		// Wide runes might leave a column free
+		for ; x < x0+w; x++ {
+			f.Set(x, y, s, ' ')
+		}
+	}
+}
+
+// cellWidth returns the number of cells r takes
+// when it is set on its own
+func cellWidth(r rune) int {
+	if runeWidth(r) == 2 {
+		return 2
+	}
+	return 1
+}
+
+// put stores a grapheme cluster that takes w cells at [x,y]. If s is
+// nil, the attributes of the cell are kept. Overwriting half of a
+// wide character blanks its other half.
+func (f *Framebuffer) put(x, y int, s *CellState, g string, w int) {
+	if x < 0 || y < 0 || x >= f.w || y >= f.h {
+		return
+	}
+	if w == 2 && x == f.w-1 {
+		// There's no room for the second half
+		g, w = " ", 1
+	}
+	i := x + y*f.w
+	f.breakWide(i)
+	if w == 2 {
+		f.breakWide(i + 1)
+	}
+	c := &f.chars[i]
+	if s != nil {
+		c.state = *s
+	}
+	r, n := utf8.DecodeRuneInString(g)
+	c.r, c.comb = r, g[n:]
+	if w == 2 {
+		f.chars[i+1] = cell{state: c.state, cont: true}
+	}
+}
+
+// breakWide blanks the other half of the wide
+// character in the cell at index i, if there's one
+func (f *Framebuffer) breakWide(i int) {
+	c := &f.chars[i]
+	if c.cont {
+		c.r, c.cont = ' ', false
+		if i%f.w > 0 {
+			f.chars[i-1].r, f.chars[i-1].comb = ' ', ""
+		}
+	} else if i%f.w < f.w-1 && f.chars[i+1].cont {
+		f.chars[i+1].r, f.chars[i+1].cont = ' ', false
+	}
+}
+
+// glyphWidth returns the number of cells the
+// character at index i takes
+func (f *Framebuffer) glyphWidth(i int) int {
+	if i%f.w < f.w-1 && f.chars[i+1].cont && !f.chars[i].cont {
+		return 2
 	}
+	return 1
 }
 
 // AttribRect sets the attributes for a rectangular region
@@ -313,31 +571,18 @@ func (f *Framebuffer) ASCIIRect(x0, y0, w, h int, doubleWidth bool, clearInside
 // the framebuffer will be ignored. Attributes for written cells
 // will remain unchanged.
 func (f *Framebuffer) SetText(x0, y0 int, t string) {
-	i := 0
-	for _, runeValue := range t {
-		if runeValue == '\n' {
-			i = 0
-			y0++
-			continue
-		}
-		f.SetRune(x0+i, y0, runeValue)
-		i++
-	}
+	f.text(x0, y0, nil, t)
 }
 
 // CenterText draws a string from left to right and top-to-bottom,
-// starting at x-len(t)/2,y0.
+// centering each line around x, starting at y0.
 // There is no wrapping mechanism, and parts of the text outside
 // the framebuffer will be ignored. Attributes for written cells
 // will remain unchanged.
 func (f *Framebuffer) CenterText(x, y0 int, t string) {
 	lines := strings.Split(t, "\n")
 	for y, s := range lines {
-		i := 0
-		for _, runeValue := range s {
-			f.SetRune(x+i-len(s)/2, y0+y, runeValue)
-			i++
-		}
+		f.text(x-StringWidth(s)/2, y0+y, nil, s)
 	}
 }
 
@@ -346,15 +591,42 @@ func (f *Framebuffer) CenterText(x, y0 int, t string) {
 // the framebuffer will be ignored. This call will also change the
 // written cells' attributes to the specified ones.
 func (f *Framebuffer) AttribText(x0, y0 int, s CellState, t string) {
-	i := 0
-	for _, runeValue := range t {
-		if runeValue == '\n' {
-			i = 0
+	f.text(x0, y0, &s, t)
+}
+
+// text draws t one grapheme cluster at a time, so wide characters
+// take two cells and combining marks share the cell of the
+// character they attach to
+func (f *Framebuffer) text(x0, y0 int, s *CellState, t string) {
+	x := x0
+	for t != "" {
+		g, w := nextGrapheme(t)
+		t = t[len(g):]
+		if g == "\n" {
+			x = x0
 			y0++
 			continue
 		}
-		f.Set(x0+i, y0, s, runeValue)
-		i++
+		f.put(x, y0, s, g, w)
+		x += w
+	}
+}
+
+// Size returns the size of the framebuffer
+func (f *Framebuffer) Size() (int, int) {
+	return f.w, f.h
+}
+
+// Resize changes the size of the framebuffer. Existing contents
+// are kept where they still fit, and new cells are filled with
+// blank spaces and default attributes.
+func (f *Framebuffer) Resize(w, h int) {
+	old := *f
+	f.w, f.h = w, h
+	f.chars = make([]cell, w*h)
+	f.Clear()
+	for y := 0; y < h && y < old.h; y++ {
+		copy(f.chars[y*w:y*w+w], old.chars[y*old.w:y*old.w+old.w])
 	}
 }
 
@@ -363,23 +635,8 @@ func (f *Framebuffer) Clear() {
 	f.SetRect(0, 0, f.w, f.h, StateDefault, ' ')
 }
 
-// Flush pushes the current state of the framebuffer to the terminal
+// Flush pushes the current state of the framebuffer to its Screen.
+// Only the cells that changed since the last Flush are sent.
 func (f *Framebuffer) Flush() {
-	fmt.Printf("\033[0;0H")
-	for y := 0; y < f.h; y++ {
-		if y != 0 {
-			fmt.Print("\n")
-		}
-		for x := 0; x < f.w; x++ {
-			c := f.chars[y*f.w+x]
-			if c.r < 32 {
-				continue
-			}
-			fmt.Printf("\033[%d;%d;%dm%c\033[0m", c.state.Attrib, c.state.FGColor, background(c.state.BGColor), c.r)
-		}
-	}
-	fmt.Printf("\033[0m")
-
-	// Move cursor to correct position
-	fmt.Printf("\033[%d;%dH", cursorPos[1]+1, cursorPos[0]+1)
+	f.screen.flush(f)
 }
@@ -0,0 +1,510 @@
+package termo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// newTestScreen creates a Screen that uses the built-in xterm
+// sequences, whatever the terminal running the tests is
+func newTestScreen(out io.Writer) *Screen {
+	s := NewScreen(&bytes.Buffer{}, out)
+	s.SetTerm("")
+	return s
+}
+
+func TestFlushOnlyChangedCells(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	f := s.NewFramebuffer(4, 2)
+	f.SetText(0, 0, "ab")
+	f.Flush()
+	if want := "\033[2J\033[1;1Hab  \033[2;1H    \033[1;1H"; out.String() != want {
+		t.Fatalf("first flush wrote %q, expected %q", out.String(), want)
+	}
+
+	out.Reset()
+	f.SetRune(1, 1, 'x')
+	f.SetRune(2, 1, 'y')
+	f.Flush()
+	if want := "\033[2;2Hxy\033[1;1H"; out.String() != want {
+		t.Fatalf("second flush wrote %q, expected %q", out.String(), want)
+	}
+
+	out.Reset()
+	f.Flush()
+	if want := "\033[1;1H"; out.String() != want {
+		t.Fatalf("unchanged flush wrote %q, expected %q", out.String(), want)
+	}
+
+	out.Reset()
+	s.ForceFullRedraw()
+	f.Flush()
+	if want := "\033[2J\033[1;1Hab  \033[2;1H xy \033[1;1H"; out.String() != want {
+		t.Fatalf("forced flush wrote %q, expected %q", out.String(), want)
+	}
+}
+
+func TestFlushCoalescesAttributes(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.SetColorMode(ColorMode16)
+	f := s.NewFramebuffer(4, 1)
+	f.AttribText(0, 0, BoldWhiteOnBlack, "ab")
+	f.AttribText(2, 0, CellState{FGColor: ColorGray.Light(), BGColor: ColorBlack}, "cd")
+	f.Flush()
+	if want := "\033[2J\033[1;1H\033[1;97;40mab\033[22mcd\033[0m\033[1;1H"; out.String() != want {
+		t.Fatalf("flush wrote %q, expected %q", out.String(), want)
+	}
+}
+
+func TestMouseModes(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.SetMouseMode(MouseClicks)
+	s.SetMouseMode(MouseDrags)
+	s.Stop()
+	want := "\033[?1000h\033[?1006h" +
+		"\033[?1006l\033[?1000l\033[?1002h\033[?1006h" +
+		"\033[?25h\033[?1006l\033[?1002l"
+	if out.String() != want {
+		t.Fatalf("mouse mode changes wrote %q, expected %q", out.String(), want)
+	}
+}
+
+func TestFramebufferResize(t *testing.T) {
+	s := NewScreen(&bytes.Buffer{}, &bytes.Buffer{})
+	f := s.NewFramebuffer(3, 2)
+	f.SetText(0, 0, "abc\ndef")
+	f.Resize(2, 3)
+	want := []rune("abde  ")
+	for i, c := range f.chars {
+		if c.r != want[i] {
+			t.Fatalf("cell %d after resize is %q, expected %q", i, c.r, want[i])
+		}
+	}
+}
+
+func TestFlushExtendedColors(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.SetColorMode(ColorModeTrueColor)
+	f := s.NewFramebuffer(3, 1)
+	f.AttribText(0, 0, CellState{FGColor: RGB(255, 128, 0), BGColor: Indexed(200)}, "a")
+	f.AttribText(1, 0, CellState{FGColor: ColorRed.Light(), BGColor: Indexed(200)}, "b")
+	f.Flush()
+	want := "\033[2J\033[1;1H\033[38;2;255;128;0;48;5;200ma\033[91mb\033[39;49m \033[1;1H"
+	if out.String() != want {
+		t.Fatalf("flush wrote %q, expected %q", out.String(), want)
+	}
+}
+
+func TestColorDowngrade(t *testing.T) {
+	tests := []struct {
+		c    Color
+		mode ColorMode
+		want Color
+	}{
+		{RGB(255, 128, 0), ColorModeTrueColor, RGB(255, 128, 0)},
+		{RGB(255, 135, 0), ColorMode256, Indexed(208)},
+		{RGB(128, 128, 128), ColorMode256, Indexed(244)},
+		{RGB(250, 10, 10), ColorMode16, ColorRed.Light()},
+		{Indexed(34), ColorMode16, ColorGreen},
+		{ColorBlue, ColorMode16, ColorBlue},
+		{ColorBlue, ColorModeNone, ColorDefault},
+		{ColorDefault, ColorMode16, ColorDefault},
+	}
+	for i, test := range tests {
+		if got := test.c.downgrade(test.mode); got != test.want {
+			t.Errorf("Downgrade test %d returned %x, expected %x", i, got, test.want)
+		}
+	}
+}
+
+func TestUnderlineColorDowngrade(t *testing.T) {
+	st := CellState{FGColor: RGB(250, 10, 10), ULColor: RGB(255, 135, 0)}
+	if got := st.downgrade(ColorMode256); got.ULColor != Indexed(208) {
+		t.Errorf("256 color underline is %x, expected %x", got.ULColor, Indexed(208))
+	}
+	got := st.downgrade(ColorMode16)
+	if got.ULColor != ColorDefault || got.FGColor != ColorRed.Light() {
+		t.Errorf("16 color state is %+v, expected no underline color", got)
+	}
+}
+
+var sgrDiffTests = []struct {
+	from, to CellState
+	sgr      string
+}{
+	{
+		to:  CellState{Attrib: AttrBold | AttrUnder | AttrItalic},
+		sgr: "\033[1;3;4m",
+	},
+	{
+		from: CellState{Attrib: AttrBold | AttrDim | AttrRev},
+		to:   CellState{Attrib: AttrDim | AttrStrike},
+		sgr:  "\033[22;27;2;9m",
+	},
+	{
+		from: CellState{Attrib: AttrUnder | AttrOverline},
+		to:   CellState{Attrib: AttrUnder | AttrCurlyUnder, ULColor: ColorRed},
+		sgr:  "\033[55;4:3;58:5:1m",
+	},
+	{
+		to:  CellState{ULColor: RGB(1, 2, 3)},
+		sgr: "\033[58:2::1:2:3m",
+	},
+	{
+		from: CellState{Attrib: AttrDoubleUnder, ULColor: RGB(1, 2, 3)},
+		to:   CellState{},
+		sgr:  "\033[24;59m",
+	},
+	{
+		from: BoldWhiteOnBlack,
+		to:   BoldWhiteOnBlack,
+		sgr:  "",
+	},
+}
+
+func TestSGRDiff(t *testing.T) {
+	for i, test := range sgrDiffTests {
+		if sgr := sgrDiff(test.from, test.to); sgr != test.sgr {
+			t.Errorf("SGR diff test %d returned %q, expected %q", i, sgr, test.sgr)
+		}
+	}
+}
+
+func TestWideText(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	f := s.NewFramebuffer(8, 1)
+	f.SetText(0, 0, "日本e\u0301x")
+	f.Flush()
+	if want := "\033[2J\033[1;1H日本e\u0301x  \033[1;1H"; out.String() != want {
+		t.Fatalf("first flush wrote %q, expected %q", out.String(), want)
+	}
+	if r, _ := f.Get(1, 0); r != 0 {
+		t.Errorf("second half of a wide character read as %q", r)
+	}
+
+	// Overwriting half of a wide character blanks the other half,
+	// and the redraw starts where the character did
+	out.Reset()
+	f.SetRune(1, 0, 'a')
+	f.Flush()
+	if want := "\033[1;1H a\033[1;1H"; out.String() != want {
+		t.Fatalf("second flush wrote %q, expected %q", out.String(), want)
+	}
+
+	// Wide characters don't fit in the last column
+	f.SetRune(7, 0, '語')
+	if r, _ := f.Get(7, 0); r != ' ' {
+		t.Errorf("wide character in the last column read as %q", r)
+	}
+
+	out.Reset()
+	f.CenterText(4, 0, "👍🏽")
+	f.Flush()
+	if want := "\033[1;3H 👍🏽\033[1;1H"; out.String() != want {
+		t.Fatalf("third flush wrote %q, expected %q", out.String(), want)
+	}
+
+	if w := StringWidth("🇯🇵a👩‍💻"); w != 5 {
+		t.Errorf("StringWidth returned %d, expected 5", w)
+	}
+}
+
+func TestAltScreen(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	f := s.NewFramebuffer(2, 1)
+	f.Flush()
+	s.EnableAltScreen()
+	s.EnableAltScreen()
+	f.Flush()
+	s.Stop()
+	want := "\033[2J\033[1;1H  \033[1;1H" +
+		"\033[?1049h" +
+		"\033[2J\033[1;1H  \033[1;1H" +
+		"\033[?25h\033[?1049l"
+	if out.String() != want {
+		t.Fatalf("alternate screen changes wrote %q, expected %q", out.String(), want)
+	}
+}
+
+func TestInlineMode(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.InitInline(false)
+	f := s.NewFramebuffer(3, 2)
+	f.SetText(0, 0, "ab")
+	f.Flush()
+	f.SetRune(1, 1, 'c')
+	f.Flush()
+	f.Resize(3, 1)
+	f.Flush()
+	s.Stop()
+	want := "\033[?25l" +
+		"\r\n\r\033[1Aab \r\033[1B   \r\033[1A" +
+		"\r\033[1B\033[1Cc\r\033[1A" +
+		"\r\033[1B\033[J\r\033[1Aab \r" +
+		"\033[?25h\r\033[J"
+	if out.String() != want {
+		t.Fatalf("inline mode wrote %q, expected %q", out.String(), want)
+	}
+
+	out.Reset()
+	s.InitInline(true)
+	f.Flush()
+	s.Stop()
+	if want := "\033[?25l\r\rab \r\033[?25h\r\r\n"; out.String() != want {
+		t.Fatalf("kept inline mode wrote %q, expected %q", out.String(), want)
+	}
+}
+
+func TestPipeScreen(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// Files that aren't terminals are used like plain readers
+	s := NewScreen(r, &bytes.Buffer{})
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init failed with %v", err)
+	}
+	defer s.Stop()
+	s.SetSize(80, 24)
+	if width, height, err := s.Size(); width != 80 || height != 24 || err != nil {
+		t.Fatalf("Size returned %d, %d, %v, expected 80, 24", width, height, err)
+	}
+	go w.Write([]byte("a"))
+	ev, err := s.ReadEvent()
+	if err != nil || ev != (KeyEvent{Key: KeyRune, Rune: 'a'}) {
+		t.Fatalf("Got %v, %v, expected 'a' key", ev, err)
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.Init()
+	s.Stop()
+	out.Reset()
+	s.Stop()
+	if out.Len() != 0 {
+		t.Fatalf("second Stop wrote %q", out.String())
+	}
+}
+
+// writeRecorder keeps the data of each Write call made to it
+type writeRecorder struct {
+	writes []string
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestStopWhileFlushing(t *testing.T) {
+	var w writeRecorder
+	s := newTestScreen(&w)
+	s.Init()
+	f := s.NewFramebuffer(8, 4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			f.SetText(0, 0, strconv.Itoa(i))
+			f.Flush()
+		}
+	}()
+	stopAll()
+	<-done
+
+	// Stop's output didn't get mixed with a frame
+	for _, out := range w.writes {
+		if out == "\033[?25h" {
+			return
+		}
+	}
+	t.Fatalf("Stop output not written on its own, writes were %q", w.writes)
+}
+
+func TestGuard(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.Init()
+	out.Reset()
+
+	var recovered interface{}
+	func() {
+		defer func() {
+			recovered = recover()
+		}()
+		Guard(func() {
+			panic("boom")
+		})
+	}()
+	if recovered != "boom" {
+		t.Fatalf("Guard let %v through, expected the original panic", recovered)
+	}
+	if want := "\033[?25h"; out.String() != want {
+		t.Fatalf("Guard wrote %q while panicking, expected %q", out.String(), want)
+	}
+	active.Lock()
+	defer active.Unlock()
+	if active.screens[s] {
+		t.Fatal("Screen still active after Guard stopped it")
+	}
+}
+
+func TestSuspendRestoresModes(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.Init()
+	s.EnableAltScreen()
+	s.SetMouseMode(MouseClicks)
+	s.EnableBracketedPaste()
+	f := s.NewFramebuffer(2, 1)
+	f.SetText(0, 0, "ab")
+	f.Flush()
+	out.Reset()
+
+	resume := s.leave()
+	if want := "\033[?25h\033[?1006l\033[?1000l\033[?2004l\033[?1049l"; out.String() != want {
+		t.Fatalf("suspending wrote %q, expected %q", out.String(), want)
+	}
+	out.Reset()
+	if err := resume(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\033[?1049h\033[?25l\033[?1000h\033[?1006h\033[?2004h"; out.String() != want {
+		t.Fatalf("resuming wrote %q, expected %q", out.String(), want)
+	}
+
+	// The program gets asked to draw again, which repaints everything
+	ev, err := s.ReadEvent()
+	if err != nil || ev != (RedrawEvent{}) {
+		t.Fatalf("Got %v, %v after resuming, expected a redraw", ev, err)
+	}
+	out.Reset()
+	f.Flush()
+	if want := "\033[2J\033[1;1Hab\033[1;1H"; out.String() != want {
+		t.Fatalf("flush after resuming wrote %q, expected %q", out.String(), want)
+	}
+	s.Stop()
+}
+
+func TestSuspendInline(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.InitInline(false)
+	f := s.NewFramebuffer(2, 2)
+	f.SetText(0, 1, "ab")
+	f.Flush()
+	out.Reset()
+
+	// The drawing area is left behind, with the cursor below it
+	resume := s.leave()
+	if want := "\033[?25h\r\033[1B\r\n"; out.String() != want {
+		t.Fatalf("suspending wrote %q, expected %q", out.String(), want)
+	}
+	out.Reset()
+	if err := resume(); err != nil {
+		t.Fatal(err)
+	}
+	ev, err := s.ReadEvent()
+	if err != nil || ev != (RedrawEvent{}) {
+		t.Fatalf("Got %v, %v after resuming, expected a redraw", ev, err)
+	}
+
+	// and a new one is made where the cursor is now
+	out.Reset()
+	f.Flush()
+	if want := "\r\n\r\033[1A  \r\033[1Bab\r\033[1A"; out.String() != want {
+		t.Fatalf("flush after resuming wrote %q, expected %q", out.String(), want)
+	}
+	s.Stop()
+}
+
+func TestCursorShapeAndColor(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestScreen(&out)
+	s.SetCursorShape(CursorBar)
+	s.SetCursorShape(CursorBar)
+	s.SetCursorColor(RGB(255, 128, 0))
+	s.SetCursorColor(ColorRed)
+	s.Stop()
+	want := "\033[6 q\033]12;#ff8000\007\033]12;#cd0000\007" +
+		"\033[?25h\033[0 q\033]112\007"
+	if out.String() != want {
+		t.Fatalf("cursor changes wrote %q, expected %q", out.String(), want)
+	}
+}
+
+// countingWriter counts the Write calls made to it
+type countingWriter struct {
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+func TestFlushWritesOnce(t *testing.T) {
+	var w countingWriter
+	s := NewScreen(&bytes.Buffer{}, &w)
+	f := s.NewFramebuffer(8, 4)
+	f.AttribText(0, 0, BoldWhiteOnBlack, "ab")
+	f.Flush()
+	if w.writes != 1 {
+		t.Fatalf("flush made %d writes, expected 1", w.writes)
+	}
+}
+
+// shortWriter accepts at most 3 bytes per Write call, and
+// fails once more than limit bytes have been written
+type shortWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	if w.Len()+len(p) > w.limit {
+		return 0, errors.New("no room left")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestFlushShortWrites(t *testing.T) {
+	w := shortWriter{limit: 1000}
+	s := newTestScreen(&w)
+	f := s.NewFramebuffer(4, 1)
+	f.SetText(0, 0, "abcd")
+	f.Flush()
+	if want := "\033[2J\033[1;1Habcd\033[1;1H"; w.String() != want {
+		t.Fatalf("flush wrote %q, expected %q", w.String(), want)
+	}
+	if s.Err() != nil {
+		t.Fatalf("Got error %v, expected none", s.Err())
+	}
+
+	w.limit = w.Len()
+	f.SetText(0, 0, "efgh")
+	f.Flush()
+	if s.Err() == nil {
+		t.Fatal("Got no error after a failed write")
+	}
+}